@@ -0,0 +1,377 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+/*
+	Everything on the attach socket travels in frames: a byte telling what
+	the frame carries, three bytes of padding and the payload's length,
+	big endian, followed by the payload. Clients send stdin and resize
+	frames, the supervisor sends stdout and stderr frames.
+*/
+
+const (
+	frameStdin  byte = 0
+	frameStdout byte = 1
+	frameStderr byte = 2
+	/* The payload is the terminal's rows and columns, 16 bits each */
+	frameResize byte = 3
+)
+
+func getAttachSocketPath(containerID string) string {
+	return getContainerHome(containerID) + "/attach.sock"
+}
+
+func encodeFrame(kind byte, payload []byte) []byte {
+	frame := make([]byte, 8+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(payload)))
+	copy(frame[8:], payload)
+	return frame
+}
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	if _, err := w.Write(encodeFrame(kind, payload)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+/*
+	Parse a detach key sequence such as "ctrl-p,ctrl-q": a comma separated
+	list of single characters or ctrl-<key>, with key one of a-z, @, [, \,
+	], ^ or _.
+*/
+
+func ParseDetachKeys(keys string) ([]byte, error) {
+	var sequence []byte
+	for _, key := range strings.Split(keys, ",") {
+		switch {
+		case len(key) == 1:
+			sequence = append(sequence, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == 6:
+			c := key[5]
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			switch {
+			case c >= 'a' && c <= 'z':
+				sequence = append(sequence, c-'a'+1)
+			case c >= '@' && c <= '_':
+				sequence = append(sequence, c-'@')
+			default:
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+	return sequence, nil
+}
+
+/*
+	Serves the workload's stdio on the container's attach socket for as
+	long as it runs. Output is sent to every client, and the input of
+	every client goes to the workload's stdin when it has one.
+
+	Every client has a queue of output frames of its own, so that a client
+	that doesn't read holds up the workload and the other clients no more
+	than once. Those that stay attachQueueLength frames behind for
+	attachSendTimeout are dropped.
+*/
+
+const attachQueueLength = 1024
+
+const attachSendTimeout = time.Second
+
+type attachServer struct {
+	listener net.Listener
+	stdin    io.Writer
+	pty      *os.File
+	mu       sync.Mutex
+	clients  map[net.Conn]chan []byte
+	closed   bool
+	senders  sync.WaitGroup
+}
+
+func newAttachServer(containerID string, stdin io.Writer, pty *os.File) (*attachServer, error) {
+	path := getAttachSocketPath(containerID)
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &attachServer{listener: listener, stdin: stdin, pty: pty, clients: map[net.Conn]chan []byte{}}
+	go s.serve()
+	return s, nil
+}
+
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		queue := make(chan []byte, attachQueueLength)
+		s.clients[conn] = queue
+		s.senders.Add(1)
+		s.mu.Unlock()
+		go s.send(conn, queue)
+		go s.handle(conn)
+	}
+}
+
+/*
+	Write out a client's queued output until its queue is closed
+*/
+
+func (s *attachServer) send(conn net.Conn, queue chan []byte) {
+	defer s.senders.Done()
+	for frame := range queue {
+		if _, err := conn.Write(frame); err != nil {
+			s.drop(conn)
+			return
+		}
+	}
+	conn.Close()
+}
+
+func (s *attachServer) handle(conn net.Conn) {
+	defer s.drop(conn)
+	for {
+		kind, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case kind == frameStdin && s.stdin != nil:
+			s.stdin.Write(payload)
+		case kind == frameResize && s.pty != nil && len(payload) == 4:
+			winsize := &unix.Winsize{
+				Row: binary.BigEndian.Uint16(payload[0:]),
+				Col: binary.BigEndian.Uint16(payload[2:]),
+			}
+			unix.IoctlSetWinsize(int(s.pty.Fd()), unix.TIOCSWINSZ, winsize)
+		}
+	}
+}
+
+func (s *attachServer) drop(conn net.Conn) {
+	s.mu.Lock()
+	if queue, ok := s.clients[conn]; ok {
+		delete(s.clients, conn)
+		close(queue)
+	}
+	s.mu.Unlock()
+	conn.Close()
+}
+
+type attachStream struct {
+	server *attachServer
+	kind   byte
+}
+
+/*
+	Queue output for every client, dropping those whose queue stays full
+*/
+
+func (w attachStream) Write(p []byte) (int, error) {
+	frame := encodeFrame(w.kind, p)
+	w.server.mu.Lock()
+	defer w.server.mu.Unlock()
+	/* One wait for all clients, closed so it stays expired */
+	expired := make(chan struct{})
+	timer := time.AfterFunc(attachSendTimeout, func() { close(expired) })
+	defer timer.Stop()
+	for conn, queue := range w.server.clients {
+		select {
+		case queue <- frame:
+			continue
+		default:
+		}
+		select {
+		case queue <- frame:
+		case <-expired:
+			delete(w.server.clients, conn)
+			close(queue)
+			conn.Close()
+		}
+	}
+	return len(p), nil
+}
+
+func (s *attachServer) stream(name string) io.Writer {
+	if name == "stderr" {
+		return attachStream{server: s, kind: frameStderr}
+	}
+	return attachStream{server: s, kind: frameStdout}
+}
+
+/*
+	Stop accepting clients and disconnect the attached ones once they have
+	what is queued for them, or attachSendTimeout has passed
+*/
+
+func (s *attachServer) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn, queue := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(attachSendTimeout))
+		close(queue)
+	}
+	s.clients = map[net.Conn]chan []byte{}
+	s.mu.Unlock()
+	s.senders.Wait()
+	return err
+}
+
+/*
+	Copy our stdin to the attach socket until the detach key sequence is
+	typed. Keys that start the sequence are held back until it is clear
+	whether they complete it.
+*/
+
+func sendAttachInput(conn net.Conn, detachKeys []byte, detached chan<- bool) {
+	buf := make([]byte, 1024)
+	matched := 0
+	for {
+		n, err := os.Stdin.Read(buf)
+		var out []byte
+		for _, c := range buf[:n] {
+			if matched < len(detachKeys) && c == detachKeys[matched] {
+				matched++
+				if matched == len(detachKeys) {
+					detached <- true
+					return
+				}
+				continue
+			}
+			out = append(out, detachKeys[:matched]...)
+			out = append(out, c)
+			matched = 0
+		}
+		if len(out) > 0 {
+			if writeFrame(conn, frameStdin, out) != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func sendTerminalSize(conn net.Conn, fd int) {
+	winsize, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return
+	}
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:], winsize.Row)
+	binary.BigEndian.PutUint16(payload[2:], winsize.Col)
+	writeFrame(conn, frameResize, payload)
+}
+
+/*
+	Attach our terminal to a running container until it exits, returning
+	its exit code, or until the detach keys are typed, which leaves it
+	running.
+*/
+
+func AttachContainer(containerID string, detachKeys string, noStdin bool) int {
+	state, err := LoadContainerState(containerID)
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	if !IsContainerRunning(state) {
+		utils.Fatalf("You cannot attach to a stopped container, start it first")
+	}
+	keys, err := ParseDetachKeys(detachKeys)
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	conn, err := net.Dial("unix", getAttachSocketPath(containerID))
+	if err != nil {
+		utils.Fatalf("Unable to attach to %s: %v", containerID, err)
+	}
+	defer conn.Close()
+
+	fd := int(os.Stdin.Fd())
+	if state.Tty && utils.IsTerminal(fd) {
+		if saved, err := utils.MakeRaw(fd); err == nil {
+			defer unix.IoctlSetTermios(fd, unix.TCSETS, saved)
+		}
+		sendTerminalSize(conn, fd)
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, unix.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				sendTerminalSize(conn, fd)
+			}
+		}()
+	}
+	detached := make(chan bool, 1)
+	if !noStdin {
+		go sendAttachInput(conn, keys, detached)
+	}
+	exited := make(chan bool)
+	go func() {
+		for {
+			kind, payload, err := readFrame(conn)
+			if err != nil {
+				break
+			}
+			if kind == frameStderr {
+				os.Stderr.Write(payload)
+			} else {
+				os.Stdout.Write(payload)
+			}
+		}
+		close(exited)
+	}()
+	select {
+	case <-detached:
+		return 0
+	case <-exited:
+	}
+	/* The supervisor records the exit code before it closes the socket */
+	state, err = LoadContainerState(containerID)
+	if err != nil || IsContainerRunning(state) {
+		return 0
+	}
+	return state.ExitCode
+}
@@ -7,12 +7,19 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 )
 
-func CreateCGroups(containerID string, createCGroupDirs bool) {
-	cgroups := []string{"/sys/fs/cgroup/memory/cig/" + containerID,
+func getCGroupDirs(containerID string) []string {
+	return []string{"/sys/fs/cgroup/memory/cig/" + containerID,
 		"/sys/fs/cgroup/pids/cig/" + containerID,
-		"/sys/fs/cgroup/cpu/cig/" + containerID}
+		"/sys/fs/cgroup/cpu/cig/" + containerID,
+		"/sys/fs/cgroup/freezer/cig/" + containerID,
+		"/sys/fs/cgroup/blkio/cig/" + containerID}
+}
+
+func CreateCGroups(containerID string, createCGroupDirs bool) {
+	cgroups := getCGroupDirs(containerID)
 
 	if createCGroupDirs {
 		utils.LogErrWithMsg(createDirsIfDontExist(cgroups),
@@ -94,11 +101,29 @@ func ConfigureCGroups(containerID string, mem int, swap int, pids int, cpus floa
 }
 
 func removeCGroups(containerID string) {
-	cgroups := []string{"/sys/fs/cgroup/memory/cig/" + containerID,
-		"/sys/fs/cgroup/pids/cig/" + containerID,
-		"/sys/fs/cgroup/cpu/cig/" + containerID}
+	cgroups := getCGroupDirs(containerID)
 
 	for _, cgroupDir := range cgroups {
 		utils.LogErrWithMsg(os.Remove(cgroupDir), "Unable to remove cgroup dir")
 	}
 }
+
+/*
+	Return the host PIDs of every process in the container's cgroup
+*/
+
+func GetCGroupPids(containerID string) ([]int, error) {
+	data, err := ioutil.ReadFile("/sys/fs/cgroup/pids/cig/" + containerID + "/cgroup.procs")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -0,0 +1,307 @@
+package container
+
+import (
+	"ContainInGo/network"
+	"ContainInGo/utils"
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Setup steps that fail, and cig processes that crash or get killed, can
+	leave mounts, network namespace files, veth links and cgroups behind
+	that no supervisor will ever tear down. The reconciler compares what is
+	on disk and in the kernel with the container state store and removes
+	whatever belongs to no container in use.
+*/
+
+/*
+	Container directories without a state are only leaked once they are
+	this old; a new container has one for a moment before its state.
+*/
+
+const orphanedDirAge = time.Minute
+
+type reconciler struct {
+	dryRun bool
+	/* Containers with a state, and those of them in use */
+	known   map[string]*utils.ContainerState
+	inUse   map[string]bool
+	actions int
+}
+
+/*
+	Carry out a cleanup action, or with dryRun only say what it would be
+*/
+
+func (r *reconciler) do(description string, action func() error) {
+	r.actions++
+	if r.dryRun {
+		log.Printf("Would %s\n", description)
+		return
+	}
+	if err := action(); err != nil {
+		log.Printf("Unable to %s: %v\n", description, err)
+		return
+	}
+	log.Printf("Cleanup: %s\n", description)
+}
+
+/*
+	The ID of the container a path under dir belongs to, if any
+*/
+
+func containerIDUnder(dir string, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") || rel == "." {
+		return ""
+	}
+	return strings.Split(rel, string(filepath.Separator))[0]
+}
+
+/*
+	Mount points from /proc/self/mountinfo under any of dirs
+*/
+
+func getMountsUnder(dirs ...string) ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var mounts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		for _, dir := range dirs {
+			if len(containerIDUnder(dir, fields[4])) > 0 {
+				mounts = append(mounts, fields[4])
+			}
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+/*
+	Unmount everything under the containers and network namespace paths
+	that isn't a container's in use, innermost mounts first
+*/
+
+func (r *reconciler) cleanupMounts() {
+	containersPath, netNsPath := utils.GetCigContainersPath(), utils.GetCigNetNsPath()
+	mounts, err := getMountsUnder(containersPath, netNsPath)
+	if err != nil {
+		log.Printf("Unable to read mounts: %v\n", err)
+		return
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	for _, mount := range mounts {
+		containerID := containerIDUnder(containersPath, mount)
+		if len(containerID) == 0 {
+			containerID = containerIDUnder(netNsPath, mount)
+		}
+		if r.inUse[containerID] {
+			continue
+		}
+		target := mount
+		r.do("unmount "+target, func() error {
+			return unix.Unmount(target, unix.MNT_DETACH)
+		})
+	}
+}
+
+/*
+	Remove network namespace files of containers not in use
+*/
+
+func (r *reconciler) cleanupNetNsFiles() {
+	entries, _ := ioutil.ReadDir(utils.GetCigNetNsPath())
+	for _, entry := range entries {
+		if r.inUse[entry.Name()] {
+			continue
+		}
+		path := utils.GetCigNetNsPath() + "/" + entry.Name()
+		r.do("remove "+path, func() error {
+			return os.Remove(path)
+		})
+	}
+}
+
+/*
+	Delete veth pairs of containers not in use. Links are named after a
+	prefix of the container ID, so they are matched by name.
+*/
+
+func (r *reconciler) cleanupVeths() {
+	names, err := network.ListVethNames()
+	if err != nil {
+		log.Printf("Unable to list network links: %v\n", err)
+		return
+	}
+	inUse := map[string]bool{}
+	for containerID := range r.inUse {
+		inUse[r.known[containerID].HostVeth] = true
+		inUse[r.known[containerID].ContainerVeth] = true
+	}
+	for _, name := range names {
+		if inUse[name] {
+			continue
+		}
+		link := name
+		r.do("delete veth "+link, func() error {
+			return network.DeleteVeth(link)
+		})
+	}
+}
+
+/*
+	Kill whatever still runs in a leaked cgroup, thawing it first as frozen
+	processes can't die, and remove it
+*/
+
+func removeLeakedCGroup(dir string) error {
+	ioutil.WriteFile(dir+"/freezer.state", []byte("THAWED"), 0644)
+	for attempt := 0; ; attempt++ {
+		data, err := ioutil.ReadFile(dir + "/cgroup.procs")
+		if err != nil {
+			return err
+		}
+		for _, field := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				unix.Kill(pid, unix.SIGKILL)
+			}
+		}
+		err = os.Remove(dir)
+		if err == nil || attempt == 50 {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (r *reconciler) cleanupCGroups() {
+	var parents []string
+	for _, dir := range getCGroupDirs("") {
+		parents = append(parents, filepath.Dir(dir))
+	}
+	for _, parent := range parents {
+		entries, _ := ioutil.ReadDir(parent)
+		for _, entry := range entries {
+			if !entry.IsDir() || r.inUse[entry.Name()] {
+				continue
+			}
+			dir := parent + "/" + entry.Name()
+			r.do("remove cgroup "+dir, func() error {
+				return removeLeakedCGroup(dir)
+			})
+		}
+	}
+}
+
+/*
+	Remove storage images and container directories that have no state
+*/
+
+func (r *reconciler) cleanupOrphanedFiles() {
+	entries, _ := ioutil.ReadDir(utils.GetCigStoragePath())
+	for _, entry := range entries {
+		containerID := strings.TrimSuffix(entry.Name(), ".img")
+		if _, ok := r.known[containerID]; ok || !strings.HasSuffix(entry.Name(), ".img") {
+			continue
+		}
+		path := getStorageImagePath(containerID)
+		r.do("remove "+path, func() error {
+			return os.Remove(path)
+		})
+	}
+	entries, _ = ioutil.ReadDir(utils.GetCigContainersPath())
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, ok := r.known[name]; ok || !entry.IsDir() || strings.HasPrefix(name, ".") ||
+			time.Since(entry.ModTime()) < orphanedDirAge {
+			continue
+		}
+		if _, err := os.Stat(getStatePath(name)); err == nil {
+			continue
+		}
+		path := getContainerHome(name)
+		r.do("remove "+path, func() error {
+			return os.RemoveAll(path)
+		})
+	}
+}
+
+/*
+	Record that containers not in use are no longer running or mounted,
+	and have no supervisor
+*/
+
+func (r *reconciler) cleanupStates() {
+	for containerID, state := range r.known {
+		if r.inUse[containerID] {
+			continue
+		}
+		stale := state.Status == utils.StatusRunning || state.Status == utils.StatusRestarting ||
+			state.Pid != 0 || state.SupervisorPid != 0 || state.Paused || len(state.Mounts) > 0
+		if !stale {
+			continue
+		}
+		id := containerID
+		r.do("mark container "+id+" as exited", func() error {
+			_, err := UpdateContainerState(id, func(state *utils.ContainerState) {
+				/* Unless it was claimed meanwhile */
+				if isContainerInUse(state) {
+					return
+				}
+				if state.Status == utils.StatusRunning || state.Status == utils.StatusRestarting {
+					state.Status = utils.StatusExited
+					state.FinishedAt = time.Now()
+				}
+				state.Pid = 0
+				state.SupervisorPid = 0
+				state.Paused = false
+				state.Mounts = nil
+			})
+			return err
+		})
+	}
+}
+
+/*
+	Tear down everything that belongs to no container in use, or with
+	dryRun only report it. Returns the number of things found.
+*/
+
+func Cleanup(dryRun bool) int {
+	r := &reconciler{dryRun: dryRun, known: map[string]*utils.ContainerState{}, inUse: map[string]bool{}}
+	states, err := ListContainerStates()
+	if err != nil {
+		log.Printf("Unable to list containers: %v\n", err)
+		return 0
+	}
+	for _, state := range states {
+		r.known[state.ID] = state
+		if isContainerInUse(state) {
+			r.inUse[state.ID] = true
+		}
+	}
+	r.cleanupMounts()
+	r.cleanupNetNsFiles()
+	r.cleanupVeths()
+	r.cleanupCGroups()
+	r.cleanupOrphanedFiles()
+	r.cleanupStates()
+	return r.actions
+}
@@ -0,0 +1,171 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Files are copied in and out of a container by a helper process that
+	chroots into the container's root file system, so that symlinks and
+	".." in the container resolve inside it and can never lead us to the
+	host. The helper and the cig command talk tar over its stdin or stdout.
+*/
+
+/*
+	The name of the archived entry for src: its base name, or "." for its
+	contents when src ends with "/." or is the root.
+*/
+
+func getArchiveName(src string) string {
+	name := filepath.Base(src)
+	if strings.HasSuffix(src, "/.") || name == "/" {
+		return "."
+	}
+	return name
+}
+
+/*
+	Make sure the container's root file system is mounted and return it,
+	along with a function that undoes any mounting done for the copy.
+*/
+
+func mountForCopy(containerID string) (string, func()) {
+	/* Claim a stopped container, so that it isn't started under us */
+	claimed := false
+	state, err := UpdateContainerState(containerID, func(state *utils.ContainerState) {
+		if len(state.Mounts) == 0 && !isContainerInUse(state) {
+			state.SupervisorPid = os.Getpid()
+			claimed = true
+		}
+	})
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	root := GetContainerFSHome(containerID) + "/mnt"
+	if !claimed {
+		if len(state.Mounts) > 0 {
+			return root, func() {}
+		}
+		utils.Fatalf("Container %s is busy, try again", containerID)
+	}
+	mountContainerStorage(containerID)
+	mountContainerFs(state)
+	return root, func() {
+		unmountContainerFs(state)
+		unmountContainerStorage(containerID)
+		updateContainerState(state, func(state *utils.ContainerState) {
+			state.SupervisorPid = 0
+		})
+	}
+}
+
+func newCopyHelper(args ...string) *exec.Cmd {
+	cmd := exec.Command("/proc/self/exe", append([]string{"cp-helper"}, args...)...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+/*
+	Copy src in the container to dst on the host, or write it to our
+	stdout as a tar stream when dst is "-".
+*/
+
+func CopyFromContainer(containerID string, src string, dst string) {
+	root, unmount := mountForCopy(containerID)
+	err := copyFromContainer(root, src, dst)
+	unmount()
+	if err != nil {
+		utils.Fatalf("Unable to copy from container: %v", err)
+	}
+}
+
+func copyFromContainer(root string, src string, dst string) error {
+	cmd := newCopyHelper("archive", root, filepath.Join("/", src), getArchiveName(src))
+	if dst == "-" {
+		cmd.Stdout = os.Stdout
+		return cmd.Run()
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	extractErr := utils.ExtractTar(out, dst, true)
+	/* Drain what is left, so that the helper doesn't block on a full pipe */
+	io.Copy(io.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return extractErr
+}
+
+/*
+	Copy src on the host to dst in the container, or extract the tar stream
+	on our stdin into dst when src is "-".
+*/
+
+func CopyToContainer(src string, containerID string, dst string) {
+	if _, err := os.Lstat(src); src != "-" && err != nil {
+		utils.Fatalf("Unable to copy to container: %v", err)
+	}
+	root, unmount := mountForCopy(containerID)
+	err := copyToContainer(src, root, dst)
+	unmount()
+	if err != nil {
+		utils.Fatalf("Unable to copy to container: %v", err)
+	}
+}
+
+func copyToContainer(src string, root string, dst string) error {
+	cmd := newCopyHelper("extract", root, filepath.Join("/", dst), strconv.FormatBool(src != "-"))
+	if src == "-" {
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	archiveErr := utils.WriteTar(in, src, getArchiveName(src))
+	in.Close()
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return archiveErr
+}
+
+/*
+	Body of the copy helper process:
+		cp-helper archive <root> <path> <name>
+		cp-helper extract <root> <path> <rebase>
+*/
+
+func RunCopyHelper(args []string) {
+	if len(args) < 4 {
+		utils.Fatalf("Usage: cp-helper archive|extract <root> <path> <name|rebase>")
+	}
+	utils.LogErrWithMsg(unix.Chroot(args[1]), "Unable to chroot")
+	utils.LogErrWithMsg(os.Chdir("/"), "Unable to chdir")
+	switch args[0] {
+	case "archive":
+		utils.LogErrWithMsg(utils.WriteTar(os.Stdout, args[2], args[3]), "Unable to archive "+args[2])
+	case "extract":
+		rebase, _ := strconv.ParseBool(args[3])
+		utils.LogErrWithMsg(utils.ExtractTar(os.Stdin, args[2], rebase), "Unable to extract to "+args[2])
+	default:
+		utils.Fatalf("Unknown cp-helper action: %s", args[0])
+	}
+}
@@ -0,0 +1,70 @@
+package container
+
+import (
+	"ContainInGo/events"
+	"ContainInGo/utils"
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+)
+
+/*
+	Record a container event. Its name, image and labels go with it, so
+	events can be filtered on them after the container is gone.
+*/
+
+func logContainerEvent(state *utils.ContainerState, action string, extra map[string]string) {
+	attributes := map[string]string{"image": state.Image}
+	if len(state.Name) > 0 {
+		attributes["name"] = state.Name
+	}
+	for key, value := range state.Labels {
+		attributes[key] = value
+	}
+	for key, value := range extra {
+		attributes[key] = value
+	}
+	events.Log(events.TypeContainer, action, state.ID, attributes)
+}
+
+/*
+	The exit code the last die event in the journal recorded for a
+	container, which outlives the container itself
+*/
+
+func getLastExitCode(containerID string) (int, bool) {
+	journal, err := os.Open(events.GetJournalPath())
+	if err != nil {
+		return 0, false
+	}
+	defer journal.Close()
+	exitCode, found := 0, false
+	reader := bufio.NewReader(journal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		event := events.Event{}
+		if json.Unmarshal(line, &event) == nil && event.Type == events.TypeContainer &&
+			event.ID == containerID && event.Action == "die" {
+			if code, err := strconv.Atoi(event.Attributes["exitCode"]); err == nil {
+				exitCode, found = code, true
+			}
+		}
+		if err != nil {
+			return exitCode, found
+		}
+	}
+}
+
+/*
+	How many times the kernel's OOM killer killed a process of the
+	container, from its memory cgroup's memory.oom_control
+*/
+
+func getOOMKillCount(containerID string) uint64 {
+	values, err := readKeyValueFile("/sys/fs/cgroup/memory/cig/" + containerID + "/memory.oom_control")
+	if err != nil {
+		return 0
+	}
+	return values["oom_kill"]
+}
@@ -1,26 +1,32 @@
 package container
 
 import (
+	"ContainInGo/events"
 	"ContainInGo/image"
 	"ContainInGo/network"
 	"ContainInGo/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"net"
 	"os"
 	"os/exec"
 	"strconv"
+	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
 /* Generate container id */
 func generateContainerID() string {
-	randBytes := make([]byte, 6)
-	rand.Read(randBytes)
-	return fmt.Sprintf("%02x%02x%02x%02x%02x%02x",
-		randBytes[0], randBytes[1], randBytes[2],
-		randBytes[3], randBytes[4], randBytes[5])
+	randBytes := make([]byte, 32)
+	if _, err := rand.Read(randBytes); err != nil {
+		utils.Fatalf("Unable to generate container ID: %v", err)
+	}
+	return hex.EncodeToString(randBytes)
 }
 
 func createDirsIfDontExist(dirs []string) error {
@@ -35,16 +41,45 @@ func createDirsIfDontExist(dirs []string) error {
 	return nil
 }
 
-func createContainerDirectories(containerID string) {
-	contHome := utils.GetCigContainersPath() + "/" + containerID
-	contDirs := []string{contHome + "/fs", contHome + "/fs/mnt", contHome + "/fs/upperdir", contHome + "/fs/workdir"}
-	if err := createDirsIfDontExist(contDirs); err != nil {
-		log.Fatalf("Unable to create required directories: %v\n", err)
+/*
+	Create the container's file system: its storage, if it is limited, and
+	its snapshot of the image. Both outlive runs of the container until it
+	is removed.
+*/
+
+func createContainer(state *utils.ContainerState) {
+	containerID := state.ID
+	if err := createDirsIfDontExist([]string{GetContainerFSHome(containerID)}); err != nil {
+		utils.Fatalf("Unable to create required directories: %v\n", err)
+	}
+	if state.Limits.StorageSize > 0 {
+		createContainerStorage(containerID, state.Limits.StorageSize)
+	}
+	mountContainerStorage(containerID)
+	if err := createDirsIfDontExist([]string{GetContainerFSHome(containerID) + "/mnt"}); err != nil {
+		utils.Fatalf("Unable to create required directories: %v\n", err)
 	}
+	if err := getContainerSnapshotter(state).Prepare(containerID, state.ImageID); err != nil {
+		utils.Fatalf("Unable to prepare container file system: %v", err)
+	}
+	unmountContainerStorage(containerID)
+}
+
+/*
+	Delete everything left of a container that is no longer running
+*/
+
+func removeContainer(state *utils.ContainerState) {
+	containerID := state.ID
+	utils.LogErrWithMsg(getContainerSnapshotter(state).Remove(containerID), "Unable to remove container file system")
+	removeContainerStorage(containerID)
+	utils.LogErrWithMsg(os.RemoveAll(getContainerHome(containerID)), "Unable to remove container directory")
+	releaseContainerName(state.Name)
+	logContainerEvent(state, "destroy", nil)
 }
 
-func prepareAndExecuteContainer(mem int, swap int, pids int, cpus float64,
-	containerID string, imageShaHex string, cmdArgs []string) {
+func prepareAndExecuteContainer(state *utils.ContainerState, attached bool) {
+	containerID := state.ID
 
 	/* Setup the network namespace  */
 	cmd := &exec.Cmd{
@@ -53,16 +88,16 @@ func prepareAndExecuteContainer(mem int, swap int, pids int, cpus float64,
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 	}
-	cmd.Run()
+	utils.LogErrWithMsg(cmd.Run(), "Unable to set up network namespace")
 
 	/* Namespace and setup the virtual interface  */
 	cmd = &exec.Cmd{
 		Path:   "/proc/self/exe",
-		Args:   []string{"/proc/self/exe", "setup-veth", containerID},
+		Args:   []string{"/proc/self/exe", "setup-veth", containerID, state.IPAddress},
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 	}
-	cmd.Run()
+	utils.LogErrWithMsg(cmd.Run(), "Unable to set up container network interface")
 	/*
 		From namespaces(7)
 		       Namespace Flag            Isolates
@@ -81,45 +116,114 @@ func prepareAndExecuteContainer(mem int, swap int, pids int, cpus float64,
 		                                 domain name
 	*/
 	var opts []string
-	if mem > 0 {
-		opts = append(opts, "--mem="+strconv.Itoa(mem))
+	if state.Limits.Memory > 0 {
+		opts = append(opts, "--mem="+strconv.Itoa(state.Limits.Memory))
 	}
-	if swap >= 0 {
-		opts = append(opts, "--swap="+strconv.Itoa(swap))
+	if state.Limits.Swap >= 0 {
+		opts = append(opts, "--swap="+strconv.Itoa(state.Limits.Swap))
 	}
-	if pids > 0 {
-		opts = append(opts, "--pids="+strconv.Itoa(pids))
+	if state.Limits.Pids > 0 {
+		opts = append(opts, "--pids="+strconv.Itoa(state.Limits.Pids))
 	}
-	if cpus > 0 {
-		opts = append(opts, "--cpus="+strconv.FormatFloat(cpus, 'f', 1, 64))
+	if state.Limits.Cpus > 0 {
+		opts = append(opts, "--cpus="+strconv.FormatFloat(state.Limits.Cpus, 'f', 1, 64))
 	}
-	opts = append(opts, "--img="+imageShaHex)
-	args := append([]string{containerID}, cmdArgs...)
+	if state.Init {
+		opts = append(opts, "--init")
+	}
+	if state.Tty {
+		opts = append(opts, "--tty")
+	}
+	opts = append(opts, "--img="+state.ImageID)
+	args := append([]string{containerID}, state.Args...)
 	args = append(opts, args...)
 	args = append([]string{"child-mode"}, args...)
 	cmd = exec.Command("/proc/self/exe", args...)
-	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	stdio := newContainerIO(state, attached)
+	defer stdio.Close()
+	stdio.setup(cmd)
 	cmd.SysProcAttr = &unix.SysProcAttr{
 		Cloneflags: unix.CLONE_NEWPID |
 			unix.CLONE_NEWNS |
 			unix.CLONE_NEWUTS |
 			unix.CLONE_NEWIPC,
 	}
-	utils.LogErr(cmd.Run())
+	utils.LogErr(cmd.Start())
+	stdio.started()
+	updateContainerState(state, func(state *utils.ContainerState) {
+		state.Pid = cmd.Process.Pid
+		state.Status = utils.StatusRunning
+		state.StartedAt = time.Now()
+	})
+	logContainerEvent(state, "start", nil)
+
+	/* The workload failing is recorded, not fatal: we still have to clean up */
+	err := cmd.Wait()
+	stdio.wait()
+	if _, ok := err.(*exec.ExitError); !ok {
+		utils.LogErr(err)
+	}
+	updateContainerState(state, func(state *utils.ContainerState) {
+		state.Pid = 0
+		state.Paused = false
+		state.Status = utils.StatusExited
+		state.ExitCode = GetExitCode(cmd.ProcessState)
+		state.FinishedAt = time.Now()
+	})
+	if getOOMKillCount(containerID) > 0 {
+		logContainerEvent(state, "oom", nil)
+	}
+	logContainerEvent(state, "die", map[string]string{"exitCode": strconv.Itoa(state.ExitCode)})
+}
+
+/*
+	The exit code of a finished process, 128+signal if a signal killed it
+*/
+
+func GetExitCode(processState *os.ProcessState) int {
+	if status, ok := processState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return utils.ExitCodeSignalBase + int(status.Signal())
+	}
+	return processState.ExitCode()
+}
+
+/*
+	The exit code for a workload that couldn't be started
+*/
+
+func GetStartErrorExitCode(err error) int {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return utils.ExitCodeNotFound
+	}
+	return utils.ExitCodeCannotInvoke
 }
 
-func ExecContainerCommand(mem int, swap int, pids int, cpus float64,
-	containerID string, imageShaHex string, args []string) {
+/*
+	Run the workload inside the container and return its exit code, or
+	ExitCodeNotFound or ExitCodeCannotInvoke if it couldn't be started.
+	Signals are always forwarded to it; with init set we also reap orphans
+	as a proper init does, see runAsInit.
+*/
+
+func ExecContainerCommand(mem int, swap int, pids int, cpus float64, init bool, tty bool,
+	containerID string, imageShaHex string, args []string) int {
 	mntPath := GetContainerFSHome(containerID) + "/mnt"
 	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	/* Output pipes or the pty set up by prepareAndExecuteContainer */
+	if tty {
+		terminal := os.NewFile(3, "tty")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = terminal, terminal, terminal
+		cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	} else {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.NewFile(3, "stdout")
+		cmd.Stderr = os.NewFile(4, "stderr")
+	}
 
 	imgConfig := image.ParseContainerConfig(imageShaHex)
-	utils.LogErrWithMsg(unix.Sethostname([]byte(containerID)), "Unable to set hostname")
+	utils.LogErrWithMsg(unix.Sethostname([]byte(containerID[:12])), "Unable to set hostname")
 	utils.LogErrWithMsg(network.JoinContainerNetworkNamespace(containerID), "Unable to join container network namespace")
 	CreateCGroups(containerID, true)
 	ConfigureCGroups(containerID, mem, swap, pids, cpus)
@@ -135,28 +239,194 @@ func ExecContainerCommand(mem int, swap int, pids int, cpus float64,
 	utils.LogErrWithMsg(unix.Mount("sysfs", "/sys", "sysfs", 0, ""), "Unable to mount sysfs")
 	network.SetupLocalInterface()
 	cmd.Env = imgConfig.Config.Env
-	cmd.Run()
+	var exitCode int
+	if init {
+		exitCode = runAsInit(cmd)
+	} else {
+		exitCode = runWorkload(cmd)
+	}
 	utils.LogErr(unix.Unmount("/dev/pts", 0))
 	utils.LogErr(unix.Unmount("/dev", 0))
 	utils.LogErr(unix.Unmount("/sys", 0))
 	utils.LogErr(unix.Unmount("/proc", 0))
 	utils.LogErr(unix.Unmount("/tmp", 0))
+	return exitCode
 }
 
-func InitContainer(mem int, swap int, pids int, cpus float64, src string, args []string) {
+/*
+	Create a container from the image src, which runs args. With opts.Detach
+	set the container is handed to a supervisor process and InitContainer
+	returns once it has started, otherwise it runs in the foreground and
+	its exit code is returned.
+*/
+
+func InitContainer(src string, args []string, opts utils.RunOptions) int {
 	containerID := generateContainerID()
 	log.Printf("New container ID: %s\n", containerID)
 	imageShaHex := image.DownloadImageIfRequired(src)
-	fmt.Printf(src+" hash : %v\n", imageShaHex)
-	createContainerDirectories(containerID)
-	mountOverlayFileSystem(containerID, imageShaHex)
-	if err := network.SetupVirtualEthOnHost(containerID); err != nil {
-		log.Fatalf("Unable to setup Veth0 on host: %v", err)
+	image.EnforceTrustPolicyForImage(src, imageShaHex)
+	log.Printf(src+" hash : %v\n", imageShaHex)
+
+	/* Reserved only now, so that the name is ours for as short as possible without a state */
+	if len(opts.Name) > 0 {
+		if err := reserveContainerName(opts.Name, containerID); err != nil {
+			utils.Fatalf("Unable to create container: %v", err)
+		}
+	}
+
+	utils.LogErrWithMsg(createDirsIfDontExist([]string{getContainerHome(containerID)}),
+		"Unable to create container directory")
+	state := &utils.ContainerState{
+		ID:            containerID,
+		Name:          opts.Name,
+		Image:         src,
+		ImageID:       imageShaHex,
+		ImageDigest:   image.GetImageDigestForRef(src, imageShaHex),
+		Args:          args,
+		Limits:        opts.Limits,
+		LogConfig:     opts.LogConfig,
+		AutoRemove:    opts.AutoRemove,
+		RestartPolicy: opts.RestartPolicy,
+		Init:          opts.Init,
+		Labels:        opts.Labels,
+		Tty:           opts.Tty,
+		OpenStdin:     opts.OpenStdin,
+		Snapshotter:   DetectSnapshotter(),
+		Status:        utils.StatusCreated,
+		/* Ours until a supervisor takes over */
+		SupervisorPid: os.Getpid(),
+		Created:       time.Now(),
+		MacAddress:    network.CreateMACAddress().String(),
+		Bridge:        network.BridgeName,
+		HostVeth:      network.GetHostVethName(containerID),
+		ContainerVeth: network.GetContainerVethName(containerID),
+	}
+	utils.LogErrWithMsg(saveNewContainerState(state), "Unable to save container state")
+	createContainer(state)
+	logContainerEvent(state, "create", nil)
+
+	if opts.Detach {
+		startDetached(state)
+		fmt.Println(containerID)
+		return 0
+	}
+	return superviseContainer(state, true)
+}
+
+/*
+	Start a created or exited container again, with the configuration it
+	was created with. With attach set it runs in the foreground and its exit
+	code is returned, otherwise it is handed to a supervisor process.
+*/
+
+func StartContainer(containerID string, attach bool) int {
+	if _, err := LoadContainerState(containerID); err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	running := false
+	state, err := UpdateContainerState(containerID, func(state *utils.ContainerState) {
+		if isContainerInUse(state) {
+			running = true
+			return
+		}
+		state.Status = utils.StatusCreated
+		/* Ours until a supervisor takes over */
+		state.SupervisorPid = os.Getpid()
+		state.StoppedByUser = false
+		state.RestartCount = 0
+	})
+	if err != nil {
+		utils.Fatalf("Unable to save container state: %v", err)
+	}
+	if running {
+		utils.Fatalf("Container %s is already running", containerID)
+	}
+	if !attach {
+		startDetached(state)
+		fmt.Println(containerID)
+		return 0
+	}
+	return superviseContainer(state, true)
+}
+
+func startDetached(state *utils.ContainerState) {
+	startedAt := state.StartedAt
+	supervisorPid := startSupervisor(state.ID)
+	_, err := UpdateContainerState(state.ID, func(state *utils.ContainerState) {
+		/* Unless the supervisor got to it first */
+		if (state.SupervisorPid == 0 || state.SupervisorPid == os.Getpid()) && state.StartedAt.Equal(startedAt) {
+			state.SupervisorPid = supervisorPid
+		}
+	})
+	utils.LogErrWithMsg(err, "Unable to save container state")
+}
+
+/*
+	Run the container, again and again as long as its restart policy asks
+	for it, and once it is done remove it if it was created with --rm.
+	Returns the container's last exit code.
+*/
+
+func superviseContainer(state *utils.ContainerState, attached bool) int {
+	backoff := minRestartBackoff
+	for {
+		runContainer(state, attached)
+		if !shouldRestart(state) {
+			break
+		}
+		/* A container that ran for a while is doing fine, start over */
+		if state.FinishedAt.Sub(state.StartedAt) >= restartBackoffResetAfter {
+			backoff = minRestartBackoff
+		}
+		log.Printf("Restarting container in %v (exit code %d)\n", backoff, state.ExitCode)
+		if !waitToRestart(state, backoff) {
+			break
+		}
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+	if state.AutoRemove {
+		removeContainer(state)
+		return state.ExitCode
 	}
-	prepareAndExecuteContainer(mem, swap, pids, cpus, containerID, imageShaHex, args)
+	_, err := UpdateContainerState(state.ID, func(state *utils.ContainerState) {
+		state.SupervisorPid = 0
+	})
+	utils.LogErrWithMsg(err, "Unable to save container state")
+	return state.ExitCode
+}
+
+/*
+	Set up the root filesystem and network of a created container, run it
+	until its process exits and tear everything down again. The container's
+	state is kept up to date along the way.
+*/
+
+func runContainer(state *utils.ContainerState, attached bool) {
+	containerID := state.ID
+	mountContainerStorage(containerID)
+	mountContainerFs(state)
+	mac, _ := net.ParseMAC(state.MacAddress)
+	if err := network.SetupVirtualEthOnHost(containerID, mac); err != nil {
+		utils.Fatalf("Unable to setup Veth0 on host: %v", err)
+	}
+	prepareAndExecuteContainer(state, attached)
 	log.Printf("Container done.\n")
+	teardownContainer(state)
+}
+
+/*
+	Undo everything runContainer set up once the container's processes are
+	gone. The container's file system stays around for the next start.
+*/
+
+func teardownContainer(state *utils.ContainerState) {
+	containerID := state.ID
 	unmountNetworkNamespace(containerID)
-	unmountContainerFs(containerID)
+	events.Log(events.TypeNetwork, "disconnect", state.Bridge, map[string]string{"container": containerID})
+	unmountContainerFs(state)
+	unmountContainerStorage(containerID)
 	removeCGroups(containerID)
-	os.RemoveAll(utils.GetCigContainersPath() + "/" + containerID)
 }
@@ -0,0 +1,196 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Container output is kept as json lines in the container's directory,
+	one entry per line of output:
+		{"log":"hello\n","stream":"stdout","time":"2021-11-02T10:00:00.000Z"}
+	When a maximum size is set, the log is rotated to json.log.1,
+	json.log.2, ... keeping at most max-file files in total.
+*/
+
+type LogEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func GetContainerLogPath(containerID string) string {
+	return getContainerHome(containerID) + "/json.log"
+}
+
+/*
+	Return the container's log files, oldest first
+*/
+
+func GetContainerLogPaths(containerID string) []string {
+	logPath := GetContainerLogPath(containerID)
+	rotated, _ := filepath.Glob(logPath + ".*")
+	index := func(path string) int {
+		n, _ := strconv.Atoi(path[len(logPath)+1:])
+		return n
+	}
+	sort.Slice(rotated, func(i, j int) bool { return index(rotated[i]) > index(rotated[j]) })
+	return append(rotated, logPath)
+}
+
+/*
+	Parse --log-opt values, e.g. max-size=10m,max-file=3
+*/
+
+func ParseLogOpts(opts []string) (utils.LogConfig, error) {
+	cfg := utils.LogConfig{MaxFiles: 1}
+	for _, opt := range opts {
+		for _, kv := range strings.Split(opt, ",") {
+			pair := strings.SplitN(kv, "=", 2)
+			if len(pair) != 2 {
+				return cfg, fmt.Errorf("invalid log option: %s", kv)
+			}
+			switch pair[0] {
+			case "max-size":
+				size, err := parseSize(pair[1])
+				if err != nil {
+					return cfg, err
+				}
+				cfg.MaxSize = size
+			case "max-file":
+				n, err := strconv.Atoi(pair[1])
+				if err != nil || n < 1 {
+					return cfg, fmt.Errorf("invalid max-file: %s", pair[1])
+				}
+				cfg.MaxFiles = n
+			default:
+				return cfg, fmt.Errorf("unsupported log option: %s", pair[0])
+			}
+		}
+	}
+	return cfg, nil
+}
+
+type containerLogger struct {
+	mu   sync.Mutex
+	path string
+	cfg  utils.LogConfig
+	file *os.File
+	size int64
+}
+
+func newContainerLogger(containerID string, cfg utils.LogConfig) (*containerLogger, error) {
+	logger := &containerLogger{path: GetContainerLogPath(containerID), cfg: cfg}
+	if err := logger.open(); err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+func (l *containerLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+/*
+	Shift json.log.N to json.log.N+1, dropping the oldest, and start a new
+	json.log. The full json.log is never truncated, but replaced, so that
+	cig logs -f can finish reading it before moving on to the new one.
+*/
+
+func (l *containerLogger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if l.cfg.MaxFiles <= 1 {
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+		return l.open()
+	}
+	os.Remove(l.path + "." + strconv.Itoa(l.cfg.MaxFiles-1))
+	for i := l.cfg.MaxFiles - 2; i >= 1; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *containerLogger) log(stream string, line string) error {
+	data, err := json.Marshal(LogEntry{Log: line, Stream: stream, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.MaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.cfg.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+func (l *containerLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+/*
+	Copy the output read from r line by line into the log as stream, and
+	to out as is when it isn't nil.
+*/
+
+func (l *containerLogger) copyStream(stream string, r io.Reader, out io.Writer) {
+	var pending []byte
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if out != nil {
+				out.Write(buf[:n])
+			}
+			pending = append(pending, buf[:n]...)
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				l.log(stream, string(pending[:i+1]))
+				pending = pending[i+1:]
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(pending) > 0 {
+		l.log(stream, string(pending))
+	}
+}
@@ -0,0 +1,147 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+	Container names are reserved by a file named after them in the .names
+	directory of the containers path, holding the ID of the container that
+	owns the name. Creating that file exclusively makes a name unique.
+*/
+
+var ErrNoSuchContainer = errors.New("No such container")
+
+var validContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func getNamesPath() string {
+	return utils.GetCigContainersPath() + "/.names"
+}
+
+func reserveContainerName(name string, containerID string) error {
+	if !validContainerName.MatchString(name) {
+		return fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if err := os.MkdirAll(getNamesPath(), 0755); err != nil {
+		return err
+	}
+	/* Keep others from taking over a name we find stale as we remove it */
+	lock, err := lockFile(getNamesPath() + "/.lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	namePath := getNamesPath() + "/" + name
+	for {
+		file, err := os.OpenFile(namePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.WriteString(containerID)
+			file.Close()
+			return err
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		owner, err := ioutil.ReadFile(namePath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(namePath)
+		if err != nil {
+			return err
+		}
+		/*
+			The owner may have gone without releasing its name. One without
+			a state may still be creating its container, so its name is
+			only taken over once the reservation is old.
+		*/
+		if _, err := LoadContainerState(string(owner)); err == nil || time.Since(info.ModTime()) < orphanedDirAge {
+			return fmt.Errorf("container name %q is already in use by %s", name, owner)
+		}
+		if err := os.Remove(namePath); err != nil {
+			return err
+		}
+	}
+}
+
+func releaseContainerName(name string) {
+	if len(name) == 0 {
+		return
+	}
+	err := os.Remove(getNamesPath() + "/" + name)
+	if err != nil && !os.IsNotExist(err) {
+		utils.LogErrWithMsg(err, "Unable to release container name")
+	}
+}
+
+/*
+	Find the container ref refers to: a full ID, a name or a prefix of
+	exactly one container's ID.
+*/
+
+func ResolveContainerID(ref string) (string, error) {
+	if len(ref) == 0 || strings.Contains(ref, "/") || strings.HasPrefix(ref, ".") {
+		return "", fmt.Errorf("%w: %s", ErrNoSuchContainer, ref)
+	}
+	if _, err := os.Stat(getStatePath(ref)); err == nil {
+		return ref, nil
+	}
+	if validContainerName.MatchString(ref) {
+		if owner, err := ioutil.ReadFile(getNamesPath() + "/" + ref); err == nil {
+			return string(owner), nil
+		}
+	}
+	entries, err := ioutil.ReadDir(utils.GetCigContainersPath())
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && strings.HasPrefix(entry.Name(), ref) {
+			matches = append(matches, entry.Name())
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", ErrNoSuchContainer, ref)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("Container ID prefix %s is ambiguous, it matches %d containers", ref, len(matches))
+}
+
+/*
+	Give a container a new name
+*/
+
+func RenameContainer(containerID string, name string) {
+	state, err := LoadContainerState(containerID)
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	if state.Name == name {
+		return
+	}
+	if err := reserveContainerName(name, containerID); err != nil {
+		utils.Fatalf("Unable to rename container: %v", err)
+	}
+	oldName := ""
+	_, err = UpdateContainerState(containerID, func(state *utils.ContainerState) {
+		oldName = state.Name
+		state.Name = name
+	})
+	if err != nil {
+		releaseContainerName(name)
+		utils.Fatalf("Unable to save container state: %v", err)
+	}
+	releaseContainerName(oldName)
+	state.Name = name
+	logContainerEvent(state, "rename", map[string]string{"oldName": oldName})
+}
@@ -2,7 +2,6 @@ package container
 
 import (
 	"ContainInGo/utils"
-	"log"
 	"os"
 
 	"golang.org/x/sys/unix"
@@ -28,6 +27,7 @@ func copyNameserverConfig(containerID string) error {
 func unmountNetworkNamespace(containerID string) {
 	netNsPath := utils.GetCigNetNsPath() + "/" + containerID
 	if err := unix.Unmount(netNsPath, 0); err != nil {
-		log.Fatalf("Uable to mount network namespace: %v at %s", err, netNsPath)
+		utils.Fatalf("Uable to mount network namespace: %v at %s", err, netNsPath)
 	}
+	utils.LogErrWithMsg(os.Remove(netNsPath), "Unable to remove network namespace file")
 }
@@ -0,0 +1,105 @@
+package container
+
+import (
+	"ContainInGo/image"
+	"ContainInGo/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	The native snapshotter doesn't need overlay support from the kernel or
+	the backing file system. It materializes the image layers into a private
+	rootfs directory, reflinking files where possible and copying otherwise,
+	and bind mounts that directory at the container's fs/mnt.
+*/
+
+type nativeSnapshotter struct{}
+
+func nativeRootfsPath(containerID string) string {
+	return GetContainerFSHome(containerID) + "/rootfs"
+}
+
+func (s *nativeSnapshotter) Prepare(containerID string, imageShaHex string) error {
+	rootfs := nativeRootfsPath(containerID)
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+	for _, layerDir := range image.GetLayerDirsForImage(imageShaHex) {
+		if err := image.ApplyLayer(layerDir+"/fs", rootfs, false, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *nativeSnapshotter) Mounts(containerID string, imageShaHex string) ([]utils.Mount, error) {
+	return []utils.Mount{{
+		Source: nativeRootfsPath(containerID),
+		Target: GetContainerFSHome(containerID) + "/mnt",
+		Type:   "bind",
+		Flags:  unix.MS_BIND,
+	}}, nil
+}
+
+/*
+	The native snapshotter keeps no record of what the container changed,
+	so a commit writes out the complete root filesystem as a layer, with
+	whiteouts for whatever the image has that the container deleted.
+*/
+
+func (s *nativeSnapshotter) Commit(containerID string, imageShaHex string, layerDir string) error {
+	rootfs := nativeRootfsPath(containerID)
+	if err := utils.CopyTree(rootfs, layerDir, false); err != nil {
+		return err
+	}
+	for _, imageLayerDir := range image.GetLayerDirsForImage(imageShaHex) {
+		if err := addWhiteouts(imageLayerDir+"/fs", rootfs, layerDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Write a whiteout to layerDir for every entry of the extracted image
+	layer at imageLayerDir that is missing from rootfs. Deleted directories
+	get a single whiteout for all of their contents.
+*/
+
+func addWhiteouts(imageLayerDir string, rootfs string, layerDir string) error {
+	return filepath.Walk(imageLayerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(imageLayerDir, path)
+		if rel == "." || strings.HasPrefix(info.Name(), image.WhiteoutPrefix) {
+			return nil
+		}
+		if existing, err := os.Lstat(filepath.Join(rootfs, rel)); err == nil {
+			/* Whatever replaced a directory hides all of its contents */
+			if info.IsDir() && !existing.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		whiteout := filepath.Join(layerDir, filepath.Dir(rel), image.WhiteoutPrefix+info.Name())
+		if err := ioutil.WriteFile(whiteout, []byte{}, 0644); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (s *nativeSnapshotter) Remove(containerID string) error {
+	return os.RemoveAll(nativeRootfsPath(containerID))
+}
@@ -3,8 +3,12 @@ package container
 import (
 	"ContainInGo/image"
 	"ContainInGo/utils"
-	"log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -13,33 +17,142 @@ func GetContainerFSHome(contanerID string) string {
 	return utils.GetCigContainersPath() + "/" + contanerID + "/fs"
 }
 
-func mountOverlayFileSystem(containerID string, imageShaHex string) {
+/*
+	The overlay snapshotter mounts the image layers as the lower directories
+	of an overlay file system, with the container's changes in its upperdir.
+*/
+
+type overlaySnapshotter struct{}
+
+func (s *overlaySnapshotter) Prepare(containerID string, imageShaHex string) error {
+	contFSHome := GetContainerFSHome(containerID)
+	return createDirsIfDontExist([]string{contFSHome + "/upperdir", contFSHome + "/workdir"})
+}
+
+func (s *overlaySnapshotter) Mounts(containerID string, imageShaHex string) ([]utils.Mount, error) {
 	var srcLayers []string
-	pathManifest := image.GetManifestPathForImage(imageShaHex)
-	mani := utils.Manifest{}
-	utils.ParseManifest(pathManifest, &mani)
-	if len(mani) == 0 || len(mani[0].Layers) == 0 {
-		log.Fatal("Could not find any layers.")
+
+	/*
+		Layers are passed to overlay through their short links, relative to
+		the cig home directory. Absolute layer paths exceed the page sized
+		limit on mount data with deep images.
+	*/
+	for _, layerDir := range image.GetLayerDirsForImage(imageShaHex) {
+		link, err := image.GetLayerLink(layerDir)
+		if err != nil {
+			return nil, err
+		}
+		srcLayers = append([]string{filepath.Base(utils.GetCigLayerLinksPath()) + "/" + link}, srcLayers...)
 	}
-	if len(mani) > 1 {
-		log.Fatal("I don't know how to handle more than one manifest.")
+	return overlayMounts(utils.GetCigHomePath(), GetContainerFSHome(containerID), srcLayers)
+}
+
+/*
+	The mounts for a container file system at contFSHome with the given
+	lower directories, topmost first and relative to home, keeping the data
+	of every mount below the page size
+*/
+
+func overlayMounts(home string, contFSHome string, srcLayers []string) ([]utils.Mount, error) {
+	var mounts []utils.Mount
+	mntOptions := overlayMountOptions(srcLayers, contFSHome)
+	if len(mntOptions) >= unix.Getpagesize() {
+		mounts, srcLayers = chainedLowerDirMounts(home, contFSHome, srcLayers)
+		mntOptions = overlayMountOptions(srcLayers, contFSHome)
+		if len(mntOptions) >= unix.Getpagesize() {
+			return nil, unix.E2BIG
+		}
 	}
+	return append(mounts, utils.Mount{
+		Source:  "none",
+		Target:  contFSHome + "/mnt",
+		Type:    "overlay",
+		Data:    mntOptions,
+		WorkDir: home,
+	}), nil
+}
 
-	imageBasePath := image.GetBasePathForImage(imageShaHex)
-	for _, layer := range mani[0].Layers {
-		srcLayers = append([]string{imageBasePath + "/" + layer[:12] + "/fs"}, srcLayers...)
-		//srcLayers = append(srcLayers, imageBasePath + "/" + layer[:12] + "/fs")
+func overlayMountOptions(lowerDirs []string, contFSHome string) string {
+	return "lowerdir=" + strings.Join(lowerDirs, ":") + ",upperdir=" + contFSHome + "/upperdir,workdir=" + contFSHome + "/workdir"
+}
+
+/*
+	When even the short layer links don't fit in a single mount, groups of
+	layers are merged into read-only overlays under the container's lower
+	directory, which then serve as the lower directories of the container.
+*/
+
+func chainedLowerDirMounts(home string, contFSHome string, lowerDirs []string) ([]utils.Mount, []string) {
+	var mounts []utils.Mount
+	var chained []string
+	var group []string
+	lowerHome := contFSHome + "/lower"
+	limit := unix.Getpagesize() - 1
+
+	addGroup := func() {
+		if len(group) == 1 {
+			chained = append(chained, home+"/"+group[0])
+		} else {
+			target := lowerHome + "/" + strconv.Itoa(len(chained))
+			mounts = append(mounts, utils.Mount{
+				Source:  "none",
+				Target:  target,
+				Type:    "overlay",
+				Flags:   unix.MS_RDONLY,
+				Data:    "lowerdir=" + strings.Join(group, ":"),
+				WorkDir: home,
+			})
+			chained = append(chained, target)
+		}
+		group = nil
 	}
-	contFSHome := GetContainerFSHome(containerID)
-	mntOptions := "lowerdir=" + strings.Join(srcLayers, ":") + ",upperdir=" + contFSHome + "/upperdir,workdir=" + contFSHome + "/workdir"
-	if err := unix.Mount("none", contFSHome+"/mnt", "overlay", 0, mntOptions); err != nil {
-		log.Fatalf("Mount failed: %v\n", err)
+	for _, lowerDir := range lowerDirs {
+		if len(group) > 0 && len("lowerdir=")+len(strings.Join(append(group, lowerDir), ":")) > limit {
+			addGroup()
+		}
+		group = append(group, lowerDir)
 	}
+	addGroup()
+	return mounts, chained
 }
 
-func unmountContainerFs(containerID string) {
-	mountedPath := utils.GetCigContainersPath() + "/" + containerID + "/fs/mnt"
-	if err := unix.Unmount(mountedPath, 0); err != nil {
-		log.Fatalf("Uable to mount container file system: %v at %s", err, mountedPath)
+/*
+	Write the upperdir out as a layer, turning overlay whiteouts (0/0 char
+	devices and opaque directories) into their .wh. file form.
+*/
+
+func (s *overlaySnapshotter) Commit(containerID string, imageShaHex string, layerDir string) error {
+	upperDir := GetContainerFSHome(containerID) + "/upperdir"
+	return filepath.Walk(upperDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(upperDir, path)
+		dst := filepath.Join(layerDir, rel)
+		if info.Mode()&os.ModeCharDevice != 0 {
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Rdev == 0 {
+				return ioutil.WriteFile(filepath.Join(filepath.Dir(dst), image.WhiteoutPrefix+info.Name()), []byte{}, 0644)
+			}
+		}
+		if err := utils.CopyEntry(path, dst, info, false); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			opaque := make([]byte, 1)
+			if n, err := unix.Lgetxattr(path, "trusted.overlay.opaque", opaque); err == nil && n == 1 && opaque[0] == 'y' {
+				return ioutil.WriteFile(filepath.Join(dst, image.WhiteoutOpaqueDir), []byte{}, 0644)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *overlaySnapshotter) Remove(containerID string) error {
+	contFSHome := GetContainerFSHome(containerID)
+	for _, dir := range []string{"/upperdir", "/workdir", "/lower"} {
+		if err := os.RemoveAll(contFSHome + dir); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,137 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const testLayerCount = 128
+
+/*
+	Lay out a synthetic image the way cig stores pulled ones under home:
+	every layer is extracted to images/<image>/<layer>/fs and has a short
+	link to it in l/. Each layer has a file of its own, and all of them
+	write "version", so the topmost layer's must win. Returns the layers as
+	paths relative to home, through their links and through their
+	directories, topmost layer first.
+*/
+
+func createTestImage(t *testing.T, home string) (links []string, dirs []string) {
+	if err := os.MkdirAll(filepath.Join(home, "l"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < testLayerCount; i++ {
+		dir := filepath.Join("images", "0123456789ab", fmt.Sprintf("%012x", i), "fs")
+		link := filepath.Join("l", fmt.Sprintf("LAYER%05d", i))
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"layer" + strconv.Itoa(i), "version"} {
+			if err := ioutil.WriteFile(filepath.Join(home, dir, name), []byte(strconv.Itoa(i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(filepath.Join(home, dir), filepath.Join(home, link)); err != nil {
+			t.Fatal(err)
+		}
+		links = append([]string{link}, links...)
+		dirs = append([]string{dir}, dirs...)
+	}
+	return links, dirs
+}
+
+func checkMountDataSize(t *testing.T, mounts []utils.Mount) {
+	for _, m := range mounts {
+		if len(m.Data) >= unix.Getpagesize() {
+			t.Errorf("Data of mount at %s is %d bytes, page size is %d", m.Target, len(m.Data), unix.Getpagesize())
+		}
+	}
+}
+
+/*
+	Mount the container file system at contFSHome the way the overlay
+	snapshotter does, and check that every layer shows through, in order
+*/
+
+func checkOverlayMounts(t *testing.T, contFSHome string, mounts []utils.Mount) {
+	if os.Geteuid() != 0 {
+		t.Log("Not mounting, that needs root")
+		return
+	}
+	if err := createDirsIfDontExist([]string{contFSHome + "/upperdir", contFSHome + "/workdir"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mountAll(mounts); err != nil {
+		if strings.Contains(err.Error(), unix.EPERM.Error()) || strings.Contains(err.Error(), unix.ENODEV.Error()) {
+			t.Skipf("Overlay isn't available here: %v", err)
+		}
+		t.Fatal(err)
+	}
+	defer unmountAll(mounts)
+	mnt := contFSHome + "/mnt"
+	for i := 0; i < testLayerCount; i++ {
+		if _, err := os.Stat(mnt + "/layer" + strconv.Itoa(i)); err != nil {
+			t.Errorf("Layer %d is missing: %v", i, err)
+		}
+	}
+	if data, err := ioutil.ReadFile(mnt + "/version"); err != nil || string(data) != strconv.Itoa(testLayerCount-1) {
+		t.Errorf("Expected the topmost layer's version, got %q (%v)", data, err)
+	}
+}
+
+func TestOverlayMountsShortLinks(t *testing.T) {
+	home := t.TempDir()
+	contFSHome := home + "/containers/c/fs"
+	links, _ := createTestImage(t, home)
+
+	/* The layers' absolute paths are what used to go past the limit */
+	var absolute []string
+	for i := 0; i < testLayerCount; i++ {
+		absolute = append(absolute, fmt.Sprintf("%s/0123456789ab/%012x/fs", utils.GetCigImagesPath(), i))
+	}
+	if len(overlayMountOptions(absolute, GetContainerFSHome("c"))) < unix.Getpagesize() {
+		t.Skip("Absolute layer paths fit in a page here, the image isn't deep enough")
+	}
+
+	mounts, err := overlayMounts(home, contFSHome, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMountDataSize(t, mounts)
+	if len(mounts) != 1 {
+		t.Fatalf("Expected a single overlay mount, got %d", len(mounts))
+	}
+	checkOverlayMounts(t, contFSHome, mounts)
+}
+
+func TestOverlayMountsChainedLowerDirs(t *testing.T) {
+	home := t.TempDir()
+	contFSHome := home + "/containers/c/fs"
+	_, dirs := createTestImage(t, home)
+	if len(overlayMountOptions(dirs, contFSHome)) < unix.Getpagesize() {
+		t.Skip("Layer directories fit in a page here, nothing to chain")
+	}
+
+	mounts, err := overlayMounts(home, contFSHome, dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMountDataSize(t, mounts)
+	if len(mounts) < 2 {
+		t.Fatalf("Expected chained lower mounts, got %d mounts", len(mounts))
+	}
+	for _, m := range mounts[:len(mounts)-1] {
+		if m.Type != "overlay" || m.Flags&unix.MS_RDONLY == 0 {
+			t.Errorf("Lower mount at %s is not a read-only overlay", m.Target)
+		}
+	}
+	checkOverlayMounts(t, contFSHome, mounts)
+}
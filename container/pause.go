@@ -0,0 +1,83 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+/*
+	Pausing freezes every process of a container through the freezer
+	controller, by writing FROZEN or THAWED to the freezer.state of the
+	container's freezer cgroup. Freezing isn't immediate, so we wait for the
+	cgroup to report it settled.
+*/
+
+const freezeTimeout = 10 * time.Second
+
+func setFrozen(containerID string, frozen bool) error {
+	path := "/sys/fs/cgroup/freezer/cig/" + containerID + "/freezer.state"
+	value := map[bool]string{true: "FROZEN", false: "THAWED"}[frozen]
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			return err
+		}
+		current, err := readFreezerState(containerID)
+		if err != nil {
+			return err
+		}
+		if current == value {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("freezer still %s after %v", current, freezeTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readFreezerState(containerID string) (string, error) {
+	data, err := ioutil.ReadFile("/sys/fs/cgroup/freezer/cig/" + containerID + "/freezer.state")
+	return strings.TrimSpace(string(data)), err
+}
+
+func PauseContainer(containerID string) {
+	state := loadRunningContainer(containerID)
+	if state.Paused {
+		utils.Fatalf("Container %s is already paused", containerID)
+	}
+	if err := setFrozen(containerID, true); err != nil {
+		/* Don't leave it half frozen */
+		setFrozen(containerID, false)
+		utils.Fatalf("Unable to pause container %s: %v", containerID, err)
+	}
+	_, err := UpdateContainerState(containerID, func(state *utils.ContainerState) {
+		state.Paused = true
+	})
+	utils.LogErrWithMsg(err, "Unable to save container state")
+	logContainerEvent(state, "pause", nil)
+	fmt.Println(containerID)
+}
+
+func UnpauseContainer(containerID string) {
+	state := loadRunningContainer(containerID)
+	if !state.Paused {
+		utils.Fatalf("Container %s is not paused", containerID)
+	}
+	thawContainer(state)
+	logContainerEvent(state, "unpause", nil)
+	fmt.Println(containerID)
+}
+
+func thawContainer(state *utils.ContainerState) {
+	if err := setFrozen(state.ID, false); err != nil {
+		utils.Fatalf("Unable to unpause container %s: %v", state.ID, err)
+	}
+	_, err := UpdateContainerState(state.ID, func(state *utils.ContainerState) {
+		state.Paused = false
+	})
+	utils.LogErrWithMsg(err, "Unable to save container state")
+}
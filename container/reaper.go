@@ -0,0 +1,115 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	We are PID 1 of the container's PID namespace, so signals sent to the
+	container, as by cig stop and cig kill, come to us. Without a handler
+	the Go runtime would exit on them and the kernel would kill the
+	workload with SIGKILL, so every catchable signal is passed on.
+*/
+
+func forwardSignal(target int, sig os.Signal) {
+	switch sig {
+	case unix.SIGCHLD:
+	case unix.SIGURG:
+		/* Used by the Go runtime to preempt goroutines */
+	default:
+		if err := unix.Kill(target, sig.(unix.Signal)); err != nil && err != unix.ESRCH {
+			log.Printf("Unable to forward %v to the workload: %v\n", sig, err)
+		}
+	}
+}
+
+/*
+	Run the workload, forwarding the signals we get to it, and return its
+	exit code once it exits
+*/
+
+func runWorkload(cmd *exec.Cmd) int {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+	defer signal.Reset()
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Unable to run %s: %v\n", cmd.Path, err)
+		return GetStartErrorExitCode(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return GetExitCode(cmd.ProcessState)
+		case sig := <-signals:
+			forwardSignal(cmd.Process.Pid, sig)
+		}
+	}
+}
+
+/*
+	Run the workload the way an init process should: every catchable signal
+	we get is forwarded to the workload's process group, and every child
+	that exits is reaped, so orphans inherited from the workload don't
+	linger as zombies. Returns the workload's exit code once it exits.
+*/
+
+func runAsInit(cmd *exec.Cmd) int {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+	defer signal.Reset()
+
+	/* With a tty the workload leads its own session, and so its own group */
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Unable to run %s: %v\n", cmd.Path, err)
+		return GetStartErrorExitCode(err)
+	}
+	pid := cmd.Process.Pid
+
+	for {
+		/* Children may have exited before we got to listen for SIGCHLD */
+		if exitCode, exited := reapChildren(pid); exited {
+			return exitCode
+		}
+		forwardSignal(-pid, <-signals)
+	}
+}
+
+/*
+	Reap every child that has exited. exited is true once the workload with
+	the given pid is among them, with its exit code.
+*/
+
+func reapChildren(workloadPid int) (exitCode int, exited bool) {
+	for {
+		var status unix.WaitStatus
+		pid, err := unix.Wait4(-1, &status, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return exitCode, exited
+		}
+		if pid == workloadPid {
+			exited = true
+			exitCode = status.ExitStatus()
+			if status.Signaled() {
+				exitCode = utils.ExitCodeSignalBase + int(status.Signal())
+			}
+		}
+	}
+}
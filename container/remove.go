@@ -0,0 +1,39 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"fmt"
+)
+
+/*
+	Remove a container that is no longer running, with its file system,
+	logs and state. With force set a running container is killed first.
+*/
+
+func RemoveContainer(containerID string, force bool) {
+	state, err := LoadContainerState(containerID)
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	if IsContainerActive(state) {
+		if !force {
+			utils.Fatalf("Container %s is running, stop it first or use -f", containerID)
+		}
+		_, err := UpdateContainerState(containerID, func(state *utils.ContainerState) {
+			state.StoppedByUser = true
+		})
+		utils.LogErrWithMsg(err, "Unable to save container state")
+		if IsContainerRunning(state) {
+			killContainerProcesses(state)
+			waitForProcessExit(state.Pid, 0)
+		}
+		waitForSupervisor(containerID)
+		if state, err = LoadContainerState(containerID); err != nil {
+			/* It was started with --rm and is gone already */
+			fmt.Println(containerID)
+			return
+		}
+	}
+	removeContainer(state)
+	fmt.Println(containerID)
+}
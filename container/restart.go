@@ -0,0 +1,104 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Restart policies:
+		no              never restart the container
+		on-failure[:N]  restart it when it exits with a non-zero code, at
+		                most N times if N is given
+		always          restart it whenever it exits
+		unless-stopped  like always
+	None of them restart a container the user stopped with cig stop.
+	Restarts back off exponentially, from minRestartBackoff up to
+	maxRestartBackoff, and start over once a run lasted longer than
+	restartBackoffResetAfter.
+*/
+
+const (
+	restartPolicyNo            = "no"
+	restartPolicyOnFailure     = "on-failure"
+	restartPolicyAlways        = "always"
+	restartPolicyUnlessStopped = "unless-stopped"
+
+	minRestartBackoff        = 100 * time.Millisecond
+	maxRestartBackoff        = time.Minute
+	restartBackoffResetAfter = 10 * time.Second
+)
+
+func ParseRestartPolicy(value string) (utils.RestartPolicy, error) {
+	policy := utils.RestartPolicy{}
+	parts := strings.SplitN(value, ":", 2)
+	policy.Name = parts[0]
+	switch policy.Name {
+	case "", restartPolicyNo:
+		policy.Name = restartPolicyNo
+	case restartPolicyOnFailure:
+		if len(parts) == 2 {
+			max, err := strconv.Atoi(parts[1])
+			if err != nil || max < 0 {
+				return policy, fmt.Errorf("invalid maximum retry count: %s", parts[1])
+			}
+			policy.MaximumRetryCount = max
+		}
+		return policy, nil
+	case restartPolicyAlways, restartPolicyUnlessStopped:
+	default:
+		return policy, fmt.Errorf("unknown restart policy: %s", policy.Name)
+	}
+	if len(parts) == 2 {
+		return policy, fmt.Errorf("maximum retry count only applies to on-failure")
+	}
+	return policy, nil
+}
+
+func shouldRestart(state *utils.ContainerState) bool {
+	if state.StoppedByUser {
+		return false
+	}
+	switch state.RestartPolicy.Name {
+	case restartPolicyAlways, restartPolicyUnlessStopped:
+		return true
+	case restartPolicyOnFailure:
+		max := state.RestartPolicy.MaximumRetryCount
+		return state.ExitCode != 0 && (max == 0 || state.RestartCount < max)
+	}
+	return false
+}
+
+/*
+	Wait out the backoff before the next restart. Returns false if the user
+	stopped the container in the meantime.
+*/
+
+func waitToRestart(state *utils.ContainerState, backoff time.Duration) bool {
+	restart := true
+	updateContainerState(state, func(state *utils.ContainerState) {
+		if state.StoppedByUser {
+			restart = false
+			return
+		}
+		state.Status = utils.StatusRestarting
+	})
+	deadline := time.Now().Add(backoff)
+	for restart && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		current, err := LoadContainerState(state.ID)
+		restart = err == nil && !current.StoppedByUser
+	}
+	updateContainerState(state, func(state *utils.ContainerState) {
+		if !restart || state.StoppedByUser {
+			restart = false
+			state.Status = utils.StatusExited
+			return
+		}
+		state.RestartCount++
+	})
+	return restart
+}
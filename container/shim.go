@@ -0,0 +1,55 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+func getSupervisorLogPath(containerID string) string {
+	return getContainerHome(containerID) + "/shim.log"
+}
+
+/*
+	Start the supervisor of a detached container. It runs in its own
+	session, detached from the terminal, with its output going to the
+	container's shim.log, so it outlives the cig command that started it.
+*/
+
+func startSupervisor(containerID string) int {
+	devNull, err := os.Open(os.DevNull)
+	utils.LogErrWithMsg(err, "Unable to open "+os.DevNull)
+	defer devNull.Close()
+	logFile, err := os.OpenFile(getSupervisorLogPath(containerID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	utils.LogErrWithMsg(err, "Unable to create supervisor log")
+	defer logFile.Close()
+
+	cmd := exec.Command("/proc/self/exe", "shim", containerID)
+	cmd.Stdin = devNull
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	utils.LogErrWithMsg(cmd.Start(), "Unable to start container supervisor")
+	pid := cmd.Process.Pid
+	utils.LogErrWithMsg(cmd.Process.Release(), "Unable to release container supervisor")
+	return pid
+}
+
+/*
+	Body of the supervisor process: run the container, record how it ended
+	and clean up after it.
+*/
+
+func RunSupervisor(containerID string) {
+	signal.Ignore(unix.SIGHUP)
+	state, err := UpdateContainerState(containerID, func(state *utils.ContainerState) {
+		state.SupervisorPid = os.Getpid()
+	})
+	if err != nil {
+		utils.Fatalf("Unable to load container state: %v\n", err)
+	}
+	superviseContainer(state, false)
+}
@@ -0,0 +1,180 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	A snapshotter materializes the root filesystem of a container from the
+	layers of its image.
+		Prepare   creates the container's writable snapshot
+		Mounts    returns the mounts that expose it at the container's fs/mnt
+		Commit    writes the container's changes to its image out as an
+		          extracted layer, with whiteouts for what it deleted
+		Remove    deletes the container's snapshot
+	Mounts are applied in order and undone in reverse order.
+*/
+
+type Snapshotter interface {
+	Prepare(containerID string, imageShaHex string) error
+	Mounts(containerID string, imageShaHex string) ([]utils.Mount, error)
+	Commit(containerID string, imageShaHex string, layerDir string) error
+	Remove(containerID string) error
+}
+
+const (
+	overlaySnapshotterName = "overlay"
+	nativeSnapshotterName  = "native"
+)
+
+func GetSnapshotter(name string) (Snapshotter, error) {
+	switch name {
+	case overlaySnapshotterName:
+		return &overlaySnapshotter{}, nil
+	case nativeSnapshotterName:
+		return &nativeSnapshotter{}, nil
+	}
+	return nil, fmt.Errorf("unknown snapshotter: %s", name)
+}
+
+/*
+	Pick the snapshotter configured in the cig config file. Without one,
+	use overlay when the kernel supports it on our storage and fall back to
+	the native snapshotter otherwise.
+*/
+
+func DetectSnapshotter() string {
+	cfg := utils.CigConfig{}
+	if err := utils.ParseCigConfig(&cfg); err != nil {
+		utils.Fatalf("Unable to parse cig config: %v\n", err)
+	}
+	if len(cfg.Snapshotter) > 0 && cfg.Snapshotter != "auto" {
+		return cfg.Snapshotter
+	}
+	if overlaySupported() {
+		return overlaySnapshotterName
+	}
+	log.Println("Overlay file system not usable, falling back to native snapshotter")
+	return nativeSnapshotterName
+}
+
+func overlaySupported() bool {
+	data, err := ioutil.ReadFile("/proc/filesystems")
+	if err != nil || !strings.Contains(string(data), "\toverlay\n") {
+		return false
+	}
+
+	/* The upper directory has to live on the same storage as container upper dirs */
+	checkDir, err := ioutil.TempDir(utils.GetCigContainersPath(), ".overlay-check-")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(checkDir)
+	if err := createDirsIfDontExist([]string{checkDir + "/lower", checkDir + "/upper",
+		checkDir + "/work", checkDir + "/merged"}); err != nil {
+		return false
+	}
+	if err := unix.Mount("none", checkDir+"/merged", "overlay", 0, "lowerdir="+checkDir+
+		"/lower,upperdir="+checkDir+"/upper,workdir="+checkDir+"/work"); err != nil {
+		return false
+	}
+	unix.Unmount(checkDir+"/merged", 0)
+	return true
+}
+
+func getContainerSnapshotter(state *utils.ContainerState) Snapshotter {
+	snapshotter, err := GetSnapshotter(state.Snapshotter)
+	if err != nil {
+		utils.Fatalf("Unable to get snapshotter: %v\n", err)
+	}
+	return snapshotter
+}
+
+func mountAll(mounts []utils.Mount) error {
+	for i, m := range mounts {
+		if err := os.MkdirAll(m.Target, 0755); err != nil {
+			unmountAll(mounts[:i])
+			return err
+		}
+		var err error
+		if len(m.WorkDir) > 0 {
+			err = mountFrom(m.WorkDir, m.Source, m.Target, m.Type, m.Flags, m.Data)
+		} else {
+			err = unix.Mount(m.Source, m.Target, m.Type, m.Flags, m.Data)
+		}
+		if err != nil {
+			unmountAll(mounts[:i])
+			return fmt.Errorf("mounting %s: %v", m.Target, err)
+		}
+	}
+	return nil
+}
+
+func unmountAll(mounts []utils.Mount) error {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if err := unix.Unmount(mounts[i].Target, 0); err != nil {
+			return fmt.Errorf("unmounting %s: %v", mounts[i].Target, err)
+		}
+	}
+	return nil
+}
+
+/*
+	Mount with dir as the working directory, so that relative paths in the
+	mount data are resolved against it. The working directory is shared by
+	all threads, so the mount is done on a thread of its own that stops
+	sharing it first. The thread is locked and never unlocked, which makes
+	the runtime end it once we are done instead of reusing it.
+*/
+
+func mountFrom(dir string, source string, target string, fstype string, flags uintptr, data string) error {
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			errs <- err
+			return
+		}
+		if err := unix.Chdir(dir); err != nil {
+			errs <- err
+			return
+		}
+		errs <- unix.Mount(source, target, fstype, flags, data)
+	}()
+	return <-errs
+}
+
+/*
+	Mount the container's root filesystem and record the mounts in its
+	state, so they can be undone without asking the snapshotter again.
+*/
+
+func mountContainerFs(state *utils.ContainerState) {
+	mounts, err := getContainerSnapshotter(state).Mounts(state.ID, state.ImageID)
+	if err != nil {
+		utils.Fatalf("Unable to get container mounts: %v\n", err)
+	}
+	if err := mountAll(mounts); err != nil {
+		utils.Fatalf("Mount failed: %v\n", err)
+	}
+	updateContainerState(state, func(state *utils.ContainerState) {
+		state.Mounts = mounts
+	})
+}
+
+func unmountContainerFs(state *utils.ContainerState) {
+	if err := unmountAll(state.Mounts); err != nil {
+		utils.Fatalf("Uable to unmount container file system: %v", err)
+	}
+	updateContainerState(state, func(state *utils.ContainerState) {
+		state.Mounts = nil
+	})
+}
@@ -0,0 +1,190 @@
+package container
+
+import (
+	"ContainInGo/network"
+	"ContainInGo/utils"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func getContainerHome(containerID string) string {
+	return utils.GetCigContainersPath() + "/" + containerID
+}
+
+func getStatePath(containerID string) string {
+	return getContainerHome(containerID) + "/state.json"
+}
+
+/*
+	Write the container's state atomically: the new state goes to a
+	temporary file first, which then replaces state.json.
+*/
+
+func SaveContainerState(state *utils.ContainerState) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(getContainerHome(state.ID), ".state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), getStatePath(state.ID))
+}
+
+func LoadContainerState(containerID string) (*utils.ContainerState, error) {
+	data, err := ioutil.ReadFile(getStatePath(containerID))
+	if err != nil {
+		return nil, err
+	}
+	state := utils.ContainerState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+/*
+	Load, change and save the state of a container while holding its lock,
+	so that concurrent updates don't overwrite each other.
+*/
+
+func UpdateContainerState(containerID string, update func(*utils.ContainerState)) (*utils.ContainerState, error) {
+	lock, err := lockContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	state, err := LoadContainerState(containerID)
+	if err != nil {
+		return nil, err
+	}
+	update(state)
+	return state, SaveContainerState(state)
+}
+
+/*
+	Update the state of a container we're running, picking up changes
+	others made to it in the meantime.
+*/
+
+func updateContainerState(state *utils.ContainerState, update func(*utils.ContainerState)) {
+	updated, err := UpdateContainerState(state.ID, update)
+	utils.LogErrWithMsg(err, "Unable to save container state")
+	*state = *updated
+}
+
+func lockContainer(containerID string) (*os.File, error) {
+	return lockFile(getContainerHome(containerID) + "/lock")
+}
+
+func lockFile(path string) (*os.File, error) {
+	lock, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+/*
+	Save the state of a new container, giving it an IP address no other
+	container has. The state is saved before others may pick an address,
+	so that concurrent runs see it.
+*/
+
+func saveNewContainerState(state *utils.ContainerState) error {
+	lock, err := lockFile(utils.GetCigContainersPath() + "/.lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	states, err := ListContainerStates()
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, other := range states {
+		used[other.IPAddress] = true
+	}
+	if state.IPAddress, err = network.CreateIPAddress(used); err != nil {
+		return err
+	}
+	return SaveContainerState(state)
+}
+
+/*
+	Return the states of all containers cig knows about
+*/
+
+func ListContainerStates() ([]*utils.ContainerState, error) {
+	var states []*utils.ContainerState
+	entries, err := ioutil.ReadDir(utils.GetCigContainersPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		state, err := LoadContainerState(entry.Name())
+		if err != nil {
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+/*
+	A container is running if its state says so and its process is still
+	around.
+*/
+
+func IsContainerRunning(state *utils.ContainerState) bool {
+	return state.Status == utils.StatusRunning && state.Pid > 0 && isProcessAlive(state.Pid)
+}
+
+func isProcessAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+/*
+	A container is active while its supervisor is still looking after it,
+	i.e. from creation until its workload has exited and been cleaned up.
+*/
+
+func IsContainerActive(state *utils.ContainerState) bool {
+	return state.Status != utils.StatusExited && state.SupervisorPid > 0 && isProcessAlive(state.SupervisorPid)
+}
+
+/*
+	A container is in use while a cig process has claimed it as its
+	supervisor, whatever the container's status: the supervisor may still
+	be tearing it down, or a cp may have its file system mounted. Nobody
+	else may then set up or tear down its mounts, network or cgroups.
+*/
+
+func isContainerInUse(state *utils.ContainerState) bool {
+	return state.SupervisorPid > 0 && isProcessAlive(state.SupervisorPid)
+}
@@ -0,0 +1,139 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	A sample of a container's resource usage. Counters only ever grow, so
+	rates such as CPU % come from the difference between two samples.
+	Network counters are from the container's side of its veth pair.
+*/
+
+type ContainerStats struct {
+	Read        time.Time
+	CPUUsage    uint64 /* nanoseconds */
+	MemoryUsage uint64
+	MemoryLimit uint64
+	PidsCurrent uint64
+	PidsLimit   uint64 /* 0 when unlimited */
+	BlockRead   uint64
+	BlockWrite  uint64
+	NetRx       uint64
+	NetTx       uint64
+}
+
+/*
+	Read a single number from a cgroup or sysfs file. "max" means there is
+	no limit and reads as 0.
+*/
+
+func readUintFile(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+/*
+	Read "key value" lines, as in memory.stat and memory.oom_control, into a map
+*/
+
+func readKeyValueFile(path string) (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			values[fields[0]], _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return values, nil
+}
+
+/*
+	The host's memory, which is the limit of containers that have none
+*/
+
+func getHostMemory() uint64 {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+/*
+	Bytes read and written by the container, from the "<device>
+	Read|Write <bytes>" lines of blkio.throttle.io_service_bytes
+*/
+
+func readBlockIO(containerID string) (read uint64, write uint64) {
+	data, _ := ioutil.ReadFile("/sys/fs/cgroup/blkio/cig/" + containerID + "/blkio.throttle.io_service_bytes")
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+	return read, write
+}
+
+/*
+	Sample the resource usage of a running container from its cgroups and
+	the counters of the host end of its veth pair: what the host end sends
+	the container receives.
+*/
+
+func GetContainerStats(state *utils.ContainerState) (ContainerStats, error) {
+	stats := ContainerStats{Read: time.Now()}
+	var err error
+	if stats.CPUUsage, err = readUintFile("/sys/fs/cgroup/cpu/cig/" + state.ID + "/cpuacct.usage"); err != nil {
+		return stats, err
+	}
+	memoryDir := "/sys/fs/cgroup/memory/cig/" + state.ID
+	if stats.MemoryUsage, err = readUintFile(memoryDir + "/memory.usage_in_bytes"); err != nil {
+		return stats, err
+	}
+	/* Page cache that can be dropped doesn't count, as with docker */
+	memStat, _ := readKeyValueFile(memoryDir + "/memory.stat")
+	if inactive := memStat["total_inactive_file"]; inactive < stats.MemoryUsage {
+		stats.MemoryUsage -= inactive
+	}
+	stats.MemoryLimit, _ = readUintFile(memoryDir + "/memory.limit_in_bytes")
+	pidsDir := "/sys/fs/cgroup/pids/cig/" + state.ID
+	stats.PidsCurrent, _ = readUintFile(pidsDir + "/pids.current")
+	stats.PidsLimit, _ = readUintFile(pidsDir + "/pids.max")
+	if hostMemory := getHostMemory(); hostMemory > 0 && (stats.MemoryLimit == 0 || stats.MemoryLimit > hostMemory) {
+		stats.MemoryLimit = hostMemory
+	}
+	stats.BlockRead, stats.BlockWrite = readBlockIO(state.ID)
+	netDir := "/sys/class/net/" + state.HostVeth + "/statistics"
+	stats.NetRx, _ = readUintFile(netDir + "/tx_bytes")
+	stats.NetTx, _ = readUintFile(netDir + "/rx_bytes")
+	return stats, nil
+}
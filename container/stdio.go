@@ -0,0 +1,138 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+/*
+	The stdio of a container's workload. Its output reaches us through
+	pipes, or the master of its pty with a tty, that are passed to the
+	child as fds 3 and 4 and copied to the container log, to the clients
+	of the attach socket, and to our own stdout and stderr when attached.
+	The child's own messages stay on our stderr.
+*/
+
+type containerIO struct {
+	state    *utils.ContainerState
+	attached bool
+	logger   *containerLogger
+	server   *attachServer
+	/* Our ends of the workload's stdio, and the ends passed to the child */
+	streams    map[string]*os.File
+	stdin      *os.File
+	childFiles []*os.File
+	childStdin *os.File
+	pty        *os.File
+	restore    func()
+	copying    sync.WaitGroup
+}
+
+func newContainerIO(state *utils.ContainerState, attached bool) *containerIO {
+	logger, err := newContainerLogger(state.ID, state.LogConfig)
+	utils.LogErrWithMsg(err, "Unable to open container log")
+	return &containerIO{state: state, attached: attached, logger: logger, streams: map[string]*os.File{}}
+}
+
+func (c *containerIO) setup(cmd *exec.Cmd) {
+	if c.state.Tty {
+		master, slave, err := utils.OpenPty()
+		utils.LogErrWithMsg(err, "Unable to allocate a pty")
+		c.pty = master
+		c.streams["stdout"] = master
+		c.childFiles = []*os.File{slave, slave}
+	} else {
+		for _, stream := range []string{"stdout", "stderr"} {
+			r, w, err := os.Pipe()
+			utils.LogErrWithMsg(err, "Unable to create "+stream+" pipe")
+			c.streams[stream] = r
+			c.childFiles = append(c.childFiles, w)
+		}
+		/* A pipe, rather than our stdin, so that attach clients can write to it */
+		if c.state.OpenStdin {
+			r, w, err := os.Pipe()
+			utils.LogErrWithMsg(err, "Unable to create stdin pipe")
+			c.stdin = w
+			c.childStdin = r
+			cmd.Stdin = r
+		}
+	}
+	cmd.ExtraFiles = c.childFiles
+}
+
+/*
+	Once the child has started, close its ends and start copying
+*/
+
+func (c *containerIO) started() {
+	for _, file := range c.childFiles {
+		file.Close()
+	}
+	if c.childStdin != nil {
+		c.childStdin.Close()
+	}
+	var stdin io.Writer
+	if c.state.OpenStdin && c.pty != nil {
+		stdin = c.pty
+	} else if c.stdin != nil {
+		stdin = c.stdin
+	}
+	server, err := newAttachServer(c.state.ID, stdin, c.pty)
+	if err != nil {
+		log.Printf("Unable to listen for attach clients: %v", err)
+	}
+	c.server = server
+	for stream, r := range c.streams {
+		var outs []io.Writer
+		if c.attached && stream == "stdout" {
+			outs = append(outs, os.Stdout)
+		} else if c.attached {
+			outs = append(outs, os.Stderr)
+		}
+		if c.server != nil {
+			outs = append(outs, c.server.stream(stream))
+		}
+		c.copying.Add(1)
+		go func(stream string, r io.Reader, out io.Writer) {
+			c.logger.copyStream(stream, r, out)
+			c.copying.Done()
+		}(stream, r, io.MultiWriter(outs...))
+	}
+	if c.pty != nil && c.attached {
+		c.restore = utils.AttachTerminal(c.pty, c.state.OpenStdin)
+	} else if c.stdin != nil && c.attached {
+		/* The workload sees the end of our input, as with a plain pipe */
+		go func() {
+			io.Copy(c.stdin, os.Stdin)
+			c.stdin.Close()
+		}()
+	}
+}
+
+/*
+	Wait for all output of the workload to be copied
+*/
+
+func (c *containerIO) wait() {
+	c.copying.Wait()
+	if c.restore != nil {
+		c.restore()
+	}
+}
+
+func (c *containerIO) Close() error {
+	if c.server != nil {
+		c.server.Close()
+	}
+	for _, r := range c.streams {
+		r.Close()
+	}
+	if c.stdin != nil {
+		c.stdin.Close()
+	}
+	return c.logger.Close()
+}
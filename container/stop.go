@@ -0,0 +1,183 @@
+package container
+
+import (
+	"ContainInGo/image"
+	"ContainInGo/utils"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const defaultStopSignal = unix.SIGTERM
+
+/*
+	Parse a signal given by name, with or without the SIG prefix, or by
+	number.
+*/
+
+func ParseSignal(name string) (unix.Signal, error) {
+	if num, err := strconv.Atoi(name); err == nil && num > 0 {
+		return unix.Signal(num), nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal: %s", name)
+}
+
+func getStopSignal(state *utils.ContainerState) unix.Signal {
+	imgConfig := image.ParseContainerConfig(state.ImageID)
+	if len(imgConfig.Config.StopSignal) == 0 {
+		return defaultStopSignal
+	}
+	sig, err := ParseSignal(imgConfig.Config.StopSignal)
+	if err != nil {
+		log.Printf("Ignoring stop signal of image: %v\n", err)
+		return defaultStopSignal
+	}
+	return sig
+}
+
+func loadRunningContainer(containerID string) *utils.ContainerState {
+	state, err := LoadContainerState(containerID)
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	if !IsContainerRunning(state) {
+		utils.Fatalf("Container %s is not running", containerID)
+	}
+	return state
+}
+
+/*
+	Send a signal to the container's PID 1, which passes it on to the
+	workload
+*/
+
+func KillContainer(containerID string, sig unix.Signal) {
+	state := loadRunningContainer(containerID)
+	if err := unix.Kill(state.Pid, sig); err != nil {
+		utils.Fatalf("Unable to signal container %s: %v", containerID, err)
+	}
+	logContainerEvent(state, "kill", map[string]string{"signal": strconv.Itoa(int(sig))})
+	fmt.Println(containerID)
+}
+
+/*
+	Stop a container: send the image's stop signal to its PID 1 and, if it
+	hasn't exited within the timeout, kill every process in its cgroup.
+	Returns once the container's supervisor has torn it down and recorded
+	its exit.
+*/
+
+func StopContainer(containerID string, timeout time.Duration) {
+	/* Keep its restart policy from bringing it back */
+	state, err := UpdateContainerState(containerID, func(state *utils.ContainerState) {
+		state.StoppedByUser = true
+	})
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	if IsContainerRunning(state) {
+		sig := getStopSignal(state)
+		utils.LogErrWithMsg(ignoreESRCH(unix.Kill(state.Pid, sig)), "Unable to signal container")
+		/* Frozen processes only get to handle the signal once thawed */
+		if state.Paused {
+			thawContainer(state)
+		}
+		if !waitForProcessExit(state.Pid, timeout) {
+			log.Printf("Container %s did not stop in %v, killing it\n", containerID, timeout)
+			killContainerProcesses(state)
+			waitForProcessExit(state.Pid, 0)
+		}
+	}
+	waitForSupervisor(containerID)
+	logContainerEvent(state, "stop", nil)
+	fmt.Println(containerID)
+}
+
+func ignoreESRCH(err error) error {
+	if err == unix.ESRCH {
+		return nil
+	}
+	return err
+}
+
+/*
+	Wait up to timeout for pid to exit; with a zero timeout wait as long as
+	it takes. Returns whether it exited.
+*/
+
+func waitForProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isProcessAlive(pid) {
+		if timeout > 0 && time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+func killContainerProcesses(state *utils.ContainerState) {
+	utils.LogErrWithMsg(ignoreESRCH(unix.Kill(state.Pid, unix.SIGKILL)), "Unable to kill container")
+	if state.Paused {
+		thawContainer(state)
+	}
+	pids, err := GetCGroupPids(state.ID)
+	if err != nil {
+		log.Printf("Unable to list container processes: %v\n", err)
+		return
+	}
+	for _, pid := range pids {
+		utils.LogErrWithMsg(ignoreESRCH(unix.Kill(pid, unix.SIGKILL)), "Unable to kill container process")
+	}
+}
+
+/*
+	Wait for the supervisor to tear the container down and record its exit.
+	When the supervisor is gone, do it here instead.
+*/
+
+func waitForSupervisor(containerID string) {
+	for {
+		state, err := LoadContainerState(containerID)
+		if err != nil {
+			/* Removed by its supervisor */
+			return
+		}
+		if state.SupervisorPid > 0 && isProcessAlive(state.SupervisorPid) {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if state.Status == utils.StatusExited {
+			return
+		}
+		log.Printf("Supervisor of container %s is gone, cleaning up\n", containerID)
+		if state.Status != utils.StatusRestarting {
+			teardownContainer(state)
+		}
+		if state.AutoRemove {
+			removeContainer(state)
+			return
+		}
+		/* Nobody saw how the workload ended, it went down with the kill */
+		_, err = UpdateContainerState(containerID, func(state *utils.ContainerState) {
+			state.Pid = 0
+			state.SupervisorPid = 0
+			state.Status = utils.StatusExited
+			state.ExitCode = utils.ExitCodeSignalBase + int(unix.SIGKILL)
+			state.FinishedAt = time.Now()
+		})
+		utils.LogErrWithMsg(err, "Unable to save container state")
+		return
+	}
+}
@@ -0,0 +1,174 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Parse --storage-opt values. The only option supported is size, which
+	takes a byte count with an optional k, m, g or t suffix.
+*/
+
+func ParseStorageOpts(opts []string) (int64, error) {
+	var size int64
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] != "size" {
+			return 0, fmt.Errorf("unsupported storage option: %s", opt)
+		}
+		bytes, err := parseSize(kv[1])
+		if err != nil {
+			return 0, err
+		}
+		size = bytes
+	}
+	return size, nil
+}
+
+func parseSize(value string) (int64, error) {
+	units := map[string]int64{"": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}
+	num := strings.TrimSuffix(strings.ToLower(value), "b")
+	unit := strings.TrimLeft(num, "0123456789.")
+	multiplier, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	n, err := strconv.ParseFloat(num[:len(num)-len(unit)], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+func getStorageImagePath(containerID string) string {
+	return utils.GetCigStoragePath() + "/" + containerID + ".img"
+}
+
+/*
+	Back the container's fs directory, which holds the upper and work
+	directories, with a sparse ext4 image of the requested size. The image
+	lives under the cig home, so a full container can't fill /var/run.
+*/
+
+func createContainerStorage(containerID string, size int64) {
+	imgPath := getStorageImagePath(containerID)
+	img, err := os.OpenFile(imgPath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		utils.Fatalf("Unable to create storage image: %v\n", err)
+	}
+	defer img.Close()
+	utils.LogErrWithMsg(img.Truncate(size), "Unable to size storage image")
+
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", imgPath).CombinedOutput(); err != nil {
+		utils.Fatalf("Unable to create file system on storage image: %v: %s\n", err, out)
+	}
+}
+
+/*
+	Mount the container's storage image, if it has one, on its fs directory
+*/
+
+func mountContainerStorage(containerID string) {
+	img, err := os.OpenFile(getStorageImagePath(containerID), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		utils.Fatalf("Unable to open storage image: %v\n", err)
+	}
+	defer img.Close()
+	loop, err := attachLoopDevice(img)
+	if err != nil {
+		utils.Fatalf("Unable to attach storage image to a loop device: %v\n", err)
+	}
+	/* The mount holds on to the device from here, so it can be closed after */
+	defer loop.Close()
+	if err := unix.Mount(loop.Name(), GetContainerFSHome(containerID), "ext4", 0, ""); err != nil {
+		utils.Fatalf("Unable to mount storage image: %v\n", err)
+	}
+}
+
+/*
+	Attach file to a free loop device and return the open device. The device
+	is set to clear itself once nothing uses it anymore, so it must be kept
+	open until the file system on it is mounted, and is cleared again when
+	that fails or once the file system is unmounted.
+*/
+
+func attachLoopDevice(file *os.File) (*os.File, error) {
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer ctl.Close()
+
+	for {
+		index, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return nil, err
+		}
+		loop, err := os.OpenFile("/dev/loop"+strconv.Itoa(index), os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		err = unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(file.Fd()))
+		if err == unix.EBUSY {
+			/* Someone else grabbed the device in the meantime */
+			loop.Close()
+			continue
+		}
+		if err != nil {
+			loop.Close()
+			return nil, err
+		}
+		info := unix.LoopInfo64{Flags: unix.LO_FLAGS_AUTOCLEAR}
+		copy(info.File_name[:], file.Name())
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, loop.Fd(), unix.LOOP_SET_STATUS64,
+			uintptr(unsafe.Pointer(&info))); errno != 0 {
+			unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+			loop.Close()
+			return nil, errno
+		}
+		return loop, nil
+	}
+}
+
+func unmountContainerStorage(containerID string) {
+	if _, err := os.Stat(getStorageImagePath(containerID)); os.IsNotExist(err) {
+		return
+	}
+	utils.LogErrWithMsg(unix.Unmount(GetContainerFSHome(containerID), 0), "Unable to unmount container storage")
+}
+
+func removeContainerStorage(containerID string) {
+	err := os.Remove(getStorageImagePath(containerID))
+	if err != nil && !os.IsNotExist(err) {
+		utils.LogErrWithMsg(err, "Unable to remove storage image")
+	}
+}
+
+/*
+	Return the bytes used in and the size of a container's storage. ok is
+	false for containers without a storage limit.
+*/
+
+func GetStorageUsage(containerID string) (used int64, size int64, ok bool) {
+	if _, err := os.Stat(getStorageImagePath(containerID)); err != nil {
+		return 0, 0, false
+	}
+	stat := unix.Statfs_t{}
+	if err := unix.Statfs(GetContainerFSHome(containerID), &stat); err != nil {
+		return 0, 0, false
+	}
+	size = int64(stat.Blocks) * int64(stat.Bsize)
+	used = size - int64(stat.Bfree)*int64(stat.Bsize)
+	return used, size, true
+}
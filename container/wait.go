@@ -0,0 +1,32 @@
+package container
+
+import (
+	"ContainInGo/utils"
+	"time"
+)
+
+/*
+	Block until a container has exited and return its exit code. Once a
+	container created with --rm is removed, its exit code is taken from the
+	event journal.
+*/
+
+func WaitContainer(containerID string) int {
+	for {
+		state, err := LoadContainerState(containerID)
+		if err != nil {
+			if exitCode, ok := getLastExitCode(containerID); ok {
+				return exitCode
+			}
+			utils.Fatalf("No such container: %s", containerID)
+		}
+		if state.Status == utils.StatusExited && !IsContainerActive(state) {
+			return state.ExitCode
+		}
+		/* Without a supervisor nothing is ever going to run it */
+		if !isContainerInUse(state) {
+			utils.Fatalf("Container %s is %s but has no supervisor", containerID, state.Status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"ContainInGo/utils"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Lifecycle events of containers, images and networks. Every event is
+	appended as a line of JSON to the journal in the cig home, which cig
+	events replays and follows. Nothing ever rewrites the journal.
+*/
+
+const (
+	TypeContainer = "container"
+	TypeImage     = "image"
+	TypeNetwork   = "network"
+)
+
+type Event struct {
+	Type       string
+	Action     string
+	ID         string
+	Attributes map[string]string `json:",omitempty"`
+	Time       time.Time
+}
+
+func GetJournalPath() string {
+	return utils.GetCigHomePath() + "/events.log"
+}
+
+/*
+	Record an event. Failing to record it is logged but doesn't fail
+	whatever the event is about.
+*/
+
+func Log(eventType string, action string, id string, attributes map[string]string) {
+	event := Event{Type: eventType, Action: action, ID: id, Attributes: attributes, Time: time.Now()}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Unable to record %s %s event: %v", eventType, action, err)
+		return
+	}
+	journal, err := os.OpenFile(GetJournalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Unable to open event journal: %v", err)
+		return
+	}
+	defer journal.Close()
+	/* Keep lines of concurrent writers from interleaving */
+	if err := unix.Flock(int(journal.Fd()), unix.LOCK_EX); err != nil {
+		log.Printf("Unable to lock event journal: %v", err)
+		return
+	}
+	if _, err := journal.Write(append(data, '\n')); err != nil {
+		log.Printf("Unable to record %s %s event: %v", eventType, action, err)
+	}
+}
@@ -0,0 +1,147 @@
+package exec
+
+import (
+	"ContainInGo/events"
+	"ContainInGo/format"
+	"ContainInGo/utils"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var eventFilters = []string{"type", "event", "container", "image", "network", "label"}
+
+func matchEvent(event *events.Event, filters format.Filters) bool {
+	/* Image names match with or without their tag */
+	matchImage := func(name string, value string) bool {
+		return name == value || strings.HasPrefix(name, value+":")
+	}
+	return filters.Match("type", func(value string) bool {
+		return event.Type == value
+	}) && filters.Match("event", func(value string) bool {
+		return event.Action == value
+	}) && filters.Match("container", func(value string) bool {
+		return event.Type == events.TypeContainer &&
+			(strings.HasPrefix(event.ID, value) || event.Attributes["name"] == value)
+	}) && filters.Match("image", func(value string) bool {
+		if event.Type == events.TypeImage {
+			return strings.HasPrefix(event.ID, value) || matchImage(event.Attributes["name"], value)
+		}
+		return event.Type == events.TypeContainer && matchImage(event.Attributes["image"], value)
+	}) && filters.Match("network", func(value string) bool {
+		return event.Type == events.TypeNetwork && event.ID == value
+	}) && filters.MatchLabels(event.Attributes)
+}
+
+type eventPrinter struct {
+	since   time.Time
+	until   time.Time
+	filters format.Filters
+	json    bool
+	tmpl    *template.Template
+}
+
+func (p *eventPrinter) print(event *events.Event) {
+	if !p.since.IsZero() && event.Time.Before(p.since) {
+		return
+	}
+	if !p.until.IsZero() && !event.Time.Before(p.until) {
+		return
+	}
+	if !matchEvent(event, p.filters) {
+		return
+	}
+	switch {
+	case p.json:
+		data, _ := json.Marshal(event)
+		fmt.Println(string(data))
+	case p.tmpl != nil:
+		if err := p.tmpl.Execute(os.Stdout, event); err != nil {
+			utils.Fatalf("Unable to format event: %v", err)
+		}
+		fmt.Println()
+	default:
+		var attributes []string
+		for key, value := range event.Attributes {
+			attributes = append(attributes, key+"="+value)
+		}
+		sort.Strings(attributes)
+		fmt.Printf("%s %s %s %s (%s)\n", event.Time.Format(time.RFC3339Nano),
+			event.Type, event.Action, event.ID, strings.Join(attributes, ", "))
+	}
+}
+
+/*
+	Print the complete events available from r, returning a trailing
+	partial line to be completed on the next read
+*/
+
+func readEvents(r *bufio.Reader, pending []byte, p *eventPrinter) []byte {
+	for {
+		line, err := r.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err != nil {
+			return pending
+		}
+		event := events.Event{}
+		if json.Unmarshal(pending, &event) == nil {
+			p.print(&event)
+		}
+		pending = nil
+	}
+}
+
+/*
+	Print the events recorded in the journal and then the new ones as they
+	happen, until interrupted or until is reached.
+*/
+
+func PrintEvents(since string, until string, filterArgs []string, formatText string) {
+	filters, err := format.ParseFilters(filterArgs, eventFilters...)
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	p := &eventPrinter{filters: filters, json: formatText == "json"}
+	if len(formatText) > 0 && !p.json {
+		if p.tmpl, err = format.ParseTemplate(formatText); err != nil {
+			utils.Fatalf("Invalid format: %v", err)
+		}
+	}
+	if len(since) > 0 {
+		if p.since, err = parseLogTime(since); err != nil {
+			utils.Fatalf("Invalid --since: %v", err)
+		}
+	}
+	if len(until) > 0 {
+		if p.until, err = parseLogTime(until); err != nil {
+			utils.Fatalf("Invalid --until: %v", err)
+		}
+	}
+
+	var reader *bufio.Reader
+	var pending []byte
+	for {
+		done := !p.until.IsZero() && time.Now().After(p.until)
+		/* Until the first event there is no journal */
+		if reader == nil {
+			if journal, err := os.Open(events.GetJournalPath()); err == nil {
+				defer journal.Close()
+				reader = bufio.NewReader(journal)
+			} else if !os.IsNotExist(err) {
+				utils.Fatalf("Unable to open event journal: %v", err)
+			}
+		}
+		if reader != nil {
+			pending = readEvents(reader, pending, p)
+		}
+		if done {
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
@@ -4,34 +4,36 @@ import (
 	"ContainInGo/container"
 	"ContainInGo/image"
 	"ContainInGo/utils"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strconv"
-	"strings"
 
 	"golang.org/x/sys/unix"
 )
 
-func getPidForRunningContainer(containerID string) int {
-	containers, err := getRunningContainers()
+/*
+	Run a command inside a running container and return its exit code.
+	With tty set the command gets a pty of its own, and with interactive
+	set it reads our stdin.
+*/
+
+func ExecInContainer(containerId string, args []string, tty bool, interactive bool) int {
+	containerConfig, err := getRunningContainerInfoForId(containerId)
 	if err != nil {
-		log.Fatalf("Unable to get running containers: %v\n", err)
+		utils.Fatalf("No such container: %v", err)
 	}
-	for _, container := range containers {
-		if container.ContainerId == containerID {
-			return container.Pid
+	/* The pty comes from the host's /dev, before we leave it */
+	var master, slave *os.File
+	if tty {
+		master, slave, err = utils.OpenPty()
+		if err != nil {
+			utils.Fatalf("Unable to allocate a pty: %v", err)
 		}
+		defer master.Close()
 	}
-	return 0
-}
-
-func ExecInContainer(containerId string) {
-	pid := getPidForRunningContainer(containerId)
-	if pid == 0 {
-		log.Fatalf("No such container!")
-	}
-	baseNsPath := "/proc/" + strconv.Itoa(pid) + "/ns"
+	baseNsPath := "/proc/" + strconv.Itoa(containerConfig.Pid) + "/ns"
 	ipcFd, ipcErr := os.Open(baseNsPath + "/ipc")
 	mntFd, mntErr := os.Open(baseNsPath + "/mnt")
 	netFd, netErr := os.Open(baseNsPath + "/net")
@@ -40,7 +42,7 @@ func ExecInContainer(containerId string) {
 
 	if ipcErr != nil || mntErr != nil || netErr != nil ||
 		pidErr != nil || utsErr != nil {
-		log.Fatalf("Unable to open namespace files!")
+		utils.Fatalf("Unable to open namespace files!")
 	}
 
 	unix.Setns(int(ipcFd.Fd()), unix.CLONE_NEWIPC)
@@ -49,24 +51,41 @@ func ExecInContainer(containerId string) {
 	unix.Setns(int(pidFd.Fd()), unix.CLONE_NEWPID)
 	unix.Setns(int(utsFd.Fd()), unix.CLONE_NEWUTS)
 
-	containerConfig, err := getRunningContainerInfoForId(containerId)
-	if err != nil {
-		log.Fatalf("Unable to get container configuration")
-	}
-	imageNameAndTag := strings.Split(containerConfig.Image, ":")
-	exists, imageShaHex := image.ImageExistByTag(imageNameAndTag[0], imageNameAndTag[1])
-	if !exists {
-		log.Fatalf("Unable to get image details")
-	}
-	imgConfig := image.ParseContainerConfig(imageShaHex)
+	imgConfig := image.ParseContainerConfig(containerConfig.ImageID)
 	containerMntPath := utils.GetCigContainersPath() + "/" + containerId + "/fs/mnt"
 	container.CreateCGroups(containerId, false)
 	utils.LogErrWithMsg(unix.Chroot(containerMntPath), "Unable to chroot")
 	os.Chdir("/")
-	cmd := exec.Command(os.Args[3], os.Args[4:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := exec.Command(args[0], args[1:]...)
+	if tty {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	} else {
+		if interactive {
+			cmd.Stdin = os.Stdin
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
 	cmd.Env = imgConfig.Config.Env
-	utils.LogErrWithMsg(cmd.Run(), "Unable to exec command in container")
+	if err := cmd.Start(); err != nil {
+		log.Printf("Unable to exec command in container: %v", err)
+		return container.GetStartErrorExitCode(err)
+	}
+	if !tty {
+		cmd.Wait()
+		return container.GetExitCode(cmd.ProcessState)
+	}
+	slave.Close()
+	restore := utils.AttachTerminal(master, interactive)
+	done := make(chan struct{})
+	go func() {
+		/* Reads fail with EIO once the command and its children are gone */
+		io.Copy(os.Stdout, master)
+		close(done)
+	}()
+	cmd.Wait()
+	<-done
+	restore()
+	return container.GetExitCode(cmd.ProcessState)
 }
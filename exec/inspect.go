@@ -0,0 +1,267 @@
+package exec
+
+import (
+	"ContainInGo/container"
+	"ContainInGo/format"
+	"ContainInGo/image"
+	"ContainInGo/network"
+	"ContainInGo/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+/*
+	The documents cig inspect prints, laid out like docker's so that
+	templates written for it mostly carry over.
+*/
+
+type containerInspectState struct {
+	Status     string
+	Running    bool
+	Paused     bool
+	Restarting bool
+	Pid        int
+	ExitCode   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+type containerInspectConfig struct {
+	Image      string
+	Cmd        []string
+	Env        []string
+	StopSignal string
+	Labels     map[string]string
+}
+
+type containerInspectHostConfig struct {
+	Memory        int64
+	MemorySwap    int64
+	PidsLimit     int
+	Cpus          float64
+	StorageSize   int64
+	AutoRemove    bool
+	Init          bool
+	RestartPolicy utils.RestartPolicy
+	LogConfig     utils.LogConfig
+}
+
+type containerInspectNetwork struct {
+	IPAddress     string
+	MacAddress    string
+	Gateway       string
+	Bridge        string
+	HostVeth      string
+	ContainerVeth string
+}
+
+type containerInspect struct {
+	ID              string `json:"Id"`
+	Name            string
+	Created         time.Time
+	Path            string
+	Args            []string
+	Image           string
+	ImageDigest     string
+	State           containerInspectState
+	Config          containerInspectConfig
+	HostConfig      containerInspectHostConfig
+	NetworkSettings containerInspectNetwork
+	Mounts          []utils.Mount
+	Snapshotter     string
+	RestartCount    int
+	LogPath         string
+	SupervisorPid   int
+	/* Bytes used in the storage of running containers with a storage limit */
+	SizeRw *int64 `json:",omitempty"`
+}
+
+type imageInspect struct {
+	ID           string `json:"Id"`
+	RepoTags     []string
+	Digest       string
+	Created      string
+	Architecture string
+	Os           string
+	Config       map[string]interface{}
+	Layers       []string
+}
+
+func megabytes(mb int) int64 {
+	if mb <= 0 {
+		return 0
+	}
+	return int64(mb) * 1024 * 1024
+}
+
+func inspectContainer(containerID string) (*containerInspect, error) {
+	state, err := container.LoadContainerState(containerID)
+	if err != nil {
+		return nil, err
+	}
+	imgConfig := image.ParseContainerConfig(state.ImageID)
+	running := container.IsContainerRunning(state)
+	info := &containerInspect{
+		ID:          state.ID,
+		Name:        state.Name,
+		Created:     state.Created,
+		Path:        state.Args[0],
+		Args:        state.Args[1:],
+		Image:       state.ImageID,
+		ImageDigest: state.ImageDigest,
+		State: containerInspectState{
+			Status:     state.Status,
+			Running:    running,
+			Paused:     running && state.Paused,
+			Restarting: state.Status == utils.StatusRestarting,
+			Pid:        state.Pid,
+			ExitCode:   state.ExitCode,
+			StartedAt:  state.StartedAt,
+			FinishedAt: state.FinishedAt,
+		},
+		Config: containerInspectConfig{
+			Image:      state.Image,
+			Cmd:        state.Args,
+			Env:        imgConfig.Config.Env,
+			StopSignal: imgConfig.Config.StopSignal,
+			Labels:     state.Labels,
+		},
+		HostConfig: containerInspectHostConfig{
+			Memory:        megabytes(state.Limits.Memory),
+			PidsLimit:     state.Limits.Pids,
+			Cpus:          state.Limits.Cpus,
+			StorageSize:   state.Limits.StorageSize,
+			AutoRemove:    state.AutoRemove,
+			Init:          state.Init,
+			RestartPolicy: state.RestartPolicy,
+			LogConfig:     state.LogConfig,
+		},
+		NetworkSettings: containerInspectNetwork{
+			IPAddress:     state.IPAddress,
+			MacAddress:    state.MacAddress,
+			Gateway:       network.BridgeIP,
+			Bridge:        state.Bridge,
+			HostVeth:      state.HostVeth,
+			ContainerVeth: state.ContainerVeth,
+		},
+		Mounts:        state.Mounts,
+		Snapshotter:   state.Snapshotter,
+		RestartCount:  state.RestartCount,
+		LogPath:       container.GetContainerLogPath(state.ID),
+		SupervisorPid: state.SupervisorPid,
+	}
+	/* Swap is limited on top of memory, docker reports the sum */
+	if state.Limits.Memory > 0 && state.Limits.Swap >= 0 {
+		info.HostConfig.MemorySwap = megabytes(state.Limits.Memory + state.Limits.Swap)
+	}
+	if info.HostConfig.PidsLimit < 0 {
+		info.HostConfig.PidsLimit = 0
+	}
+	if info.HostConfig.Cpus < 0 {
+		info.HostConfig.Cpus = 0
+	}
+	/* Storage is only mounted while the container runs */
+	if running {
+		if used, _, ok := container.GetStorageUsage(state.ID); ok {
+			info.SizeRw = &used
+		}
+	}
+	return info, nil
+}
+
+func inspectImage(imageShaHex string) (*imageInspect, error) {
+	data, err := ioutil.ReadFile(image.GetConfigPathForImage(imageShaHex))
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Created      string                 `json:"created"`
+		Architecture string                 `json:"architecture"`
+		Os           string                 `json:"os"`
+		Config       map[string]interface{} `json:"config"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	info := &imageInspect{
+		ID:           imageShaHex,
+		RepoTags:     image.GetImageRefsForHash(imageShaHex),
+		Digest:       image.GetImageDigest(imageShaHex),
+		Created:      config.Created,
+		Architecture: config.Architecture,
+		Os:           config.Os,
+		Config:       config.Config,
+	}
+	for _, layerDir := range image.GetLayerDirsForImage(imageShaHex) {
+		info.Layers = append(info.Layers, filepath.Base(layerDir))
+	}
+	return info, nil
+}
+
+/*
+	Look ref up as a container and then as an image
+*/
+
+func inspectObject(ref string) (interface{}, error) {
+	containerID, err := container.ResolveContainerID(ref)
+	if err == nil {
+		return inspectContainer(containerID)
+	}
+	if !errors.Is(err, container.ErrNoSuchContainer) {
+		return nil, err
+	}
+	if imageShaHex, ok := image.GetImageHashForRef(ref); ok {
+		return inspectImage(imageShaHex)
+	}
+	return nil, fmt.Errorf("No such container or image: %s", ref)
+}
+
+/*
+	Print details of containers and images as a JSON array, or each of them
+	through the Go template format.
+*/
+
+func InspectObjects(refs []string, tmplText string) {
+	var tmpl *template.Template
+	if len(tmplText) > 0 {
+		var err error
+		tmpl, err = format.ParseTemplate(tmplText)
+		if err != nil {
+			utils.Fatalf("Invalid format: %v", err)
+		}
+	}
+
+	failed := false
+	objects := []interface{}{}
+	for _, ref := range refs {
+		object, err := inspectObject(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = true
+			continue
+		}
+		objects = append(objects, object)
+	}
+
+	if tmpl != nil {
+		for _, object := range objects {
+			if err := tmpl.Execute(os.Stdout, object); err != nil {
+				utils.Fatalf("Unable to format: %v", err)
+			}
+			fmt.Println()
+		}
+	} else {
+		data, err := json.MarshalIndent(objects, "", "    ")
+		utils.LogErrWithMsg(err, "Unable to encode details")
+		fmt.Println(string(data))
+	}
+	if failed {
+		os.Exit(utils.ExitCodeRuntimeError)
+	}
+}
@@ -0,0 +1,181 @@
+package exec
+
+import (
+	"ContainInGo/container"
+	"ContainInGo/utils"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+/*
+	Parse a --since or --until value: an RFC3339 timestamp, unix seconds or
+	a duration relative to now, e.g. 10m.
+*/
+
+func parseLogTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", value)
+}
+
+type logPrinter struct {
+	since      time.Time
+	until      time.Time
+	timestamps bool
+}
+
+func (p *logPrinter) matches(entry *container.LogEntry) bool {
+	if !p.since.IsZero() && entry.Time.Before(p.since) {
+		return false
+	}
+	if !p.until.IsZero() && !entry.Time.Before(p.until) {
+		return false
+	}
+	return true
+}
+
+func (p *logPrinter) print(entry *container.LogEntry) {
+	out := os.Stdout
+	if entry.Stream == "stderr" {
+		out = os.Stderr
+	}
+	if p.timestamps {
+		fmt.Fprintf(out, "%s %s", entry.Time.Format(time.RFC3339Nano), entry.Log)
+	} else {
+		fmt.Fprint(out, entry.Log)
+	}
+}
+
+/*
+	Read the complete log lines available from r. A trailing partial line,
+	still being written, is returned so it can be completed on the next read.
+*/
+
+func readLogEntries(r *bufio.Reader, pending []byte, p *logPrinter, entries []*container.LogEntry) ([]*container.LogEntry, []byte) {
+	for {
+		line, err := r.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err != nil {
+			return entries, pending
+		}
+		entry := container.LogEntry{}
+		if json.Unmarshal(pending, &entry) == nil && p.matches(&entry) {
+			entries = append(entries, &entry)
+		}
+		pending = nil
+	}
+}
+
+func sameFile(file *os.File, path string) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return current.Sys().(*syscall.Stat_t).Ino == opened.Sys().(*syscall.Stat_t).Ino
+}
+
+/*
+	Print the logs of a container, running or not. With follow, keep
+	printing new output, across rotations, until the container is done.
+*/
+
+func PrintContainerLogs(containerID string, follow bool, since string, until string, tail int, timestamps bool) {
+	state, err := container.LoadContainerState(containerID)
+	if err != nil {
+		utils.Fatalf("No such container: %s", containerID)
+	}
+	p := &logPrinter{timestamps: timestamps}
+	if len(since) > 0 {
+		if p.since, err = parseLogTime(since); err != nil {
+			utils.Fatalf("Invalid --since: %v", err)
+		}
+	}
+	if len(until) > 0 {
+		if p.until, err = parseLogTime(until); err != nil {
+			utils.Fatalf("Invalid --until: %v", err)
+		}
+	}
+
+	/* Everything up to the current log file comes from the rotated files */
+	var entries []*container.LogEntry
+	paths := container.GetContainerLogPaths(state.ID)
+	for _, path := range paths[:len(paths)-1] {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		entries, _ = readLogEntries(bufio.NewReader(file), nil, p, entries)
+		file.Close()
+	}
+	logPath := paths[len(paths)-1]
+	file, err := os.Open(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fatalf("Unable to open container log: %v", err)
+	}
+	var reader *bufio.Reader
+	var pending []byte
+	if file != nil {
+		reader = bufio.NewReader(file)
+		entries, pending = readLogEntries(reader, nil, p, entries)
+	}
+	if tail >= 0 && len(entries) > tail {
+		entries = entries[len(entries)-tail:]
+	}
+	for _, entry := range entries {
+		p.print(entry)
+	}
+	if !follow {
+		return
+	}
+
+	for {
+		done := !p.until.IsZero() && time.Now().After(p.until)
+		if state, err := container.LoadContainerState(containerID); err != nil || !container.IsContainerActive(state) {
+			done = true
+		}
+		if file != nil {
+			entries, pending = readLogEntries(reader, pending, p, nil)
+			for _, entry := range entries {
+				p.print(entry)
+			}
+		}
+		/* The file we hold was rotated away, move on once it is fully read */
+		if file == nil || !sameFile(file, logPath) {
+			if next, err := os.Open(logPath); err == nil {
+				if file != nil {
+					/* What was written to it since we last read, up to the rotation */
+					entries, _ = readLogEntries(reader, pending, p, nil)
+					for _, entry := range entries {
+						p.print(entry)
+					}
+					file.Close()
+				}
+				file, reader, pending = next, bufio.NewReader(next), nil
+				continue
+			}
+		}
+		if done {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	if file != nil {
+		file.Close()
+	}
+}
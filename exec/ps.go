@@ -1,150 +1,180 @@
 package exec
 
 import (
+	"ContainInGo/container"
+	"ContainInGo/events"
+	"ContainInGo/format"
 	"ContainInGo/image"
 	"ContainInGo/utils"
-	"bufio"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"log"
+	"time"
 )
 
-/*
-		Get the list of running container IDs.
+func getRunningContainerInfoForId(containerID string) (*utils.ContainerState, error) {
+	state, err := container.LoadContainerState(containerID)
+	if err != nil {
+		return nil, err
+	}
+	if !container.IsContainerRunning(state) {
+		return nil, fmt.Errorf("container %s is not running", containerID)
+	}
+	return state, nil
+}
 
-		Implementation logic:
-		- Cig creates multiple folders in the /sys/fs/cgroup hierarchy
-		- For example, for setting cpu limits, Cig uses /sys/fs/cgroup/cpu/cig
-	- Inside that folder are folders one each for currently running containers
-	- Those folder names are the container IDs we create.
-	- getContainerInfoForId() does more work. It gathers more information about running
-		containers. See struct runningContainerInfo for details.
-	- Inside each of those folders is a "cgroup.procs" file that has the list
-		of PIDs of processes inside of that container. From the PID, we can
-		get the mounted path from which the process was started. From that
-		mounted path, we can get the image of the containers since containers
-		are mounted via the overlay file system.
+/*
+	Describe how long a container has been up, or how it ended
 */
 
-func getRunningContainers() ([]utils.RunningContainerInfo, error) {
-	var containers []utils.RunningContainerInfo
-	basePath := "/sys/fs/cgroup/cpu/cig"
+func describeContainerStatus(state *utils.ContainerState) string {
+	switch {
+	case container.IsContainerRunning(state) && state.Paused:
+		return "Up " + utils.HumanDuration(time.Since(state.StartedAt)) + " (Paused)"
+	case container.IsContainerRunning(state):
+		return "Up " + utils.HumanDuration(time.Since(state.StartedAt))
+	case state.Status == utils.StatusRestarting:
+		return fmt.Sprintf("Restarting (%d) %s ago", state.ExitCode, utils.HumanDuration(time.Since(state.FinishedAt)))
+	case state.Status == utils.StatusExited:
+		return fmt.Sprintf("Exited (%d) %s ago", state.ExitCode, utils.HumanDuration(time.Since(state.FinishedAt)))
+	}
+	return "Created"
+}
 
-	entries, err := ioutil.ReadDir(basePath)
-	if os.IsNotExist(err) {
-		return containers, nil
-	} else {
-		if err != nil {
-			return nil, err
-		} else {
-			for _, entry := range entries {
-				if entry.IsDir() {
-					container, _ := getRunningContainerInfoForId(entry.Name())
-					if container.Pid > 0 {
-						containers = append(containers, container)
-					}
-				}
-			}
-			return containers, nil
-		}
+/*
+	The state of a container in one word: created, running, paused,
+	restarting or exited
+*/
+
+func getContainerStateName(state *utils.ContainerState) string {
+	switch {
+	case container.IsContainerRunning(state) && state.Paused:
+		return "paused"
+	case container.IsContainerRunning(state):
+		return utils.StatusRunning
+	case state.Status == utils.StatusCreated || state.Status == utils.StatusRestarting:
+		return state.Status
 	}
+	return utils.StatusExited
 }
 
-func getRunningContainerInfoForId(containerID string) (utils.RunningContainerInfo, error) {
-	container := utils.RunningContainerInfo{}
-	var procs []string
-	basePath := "/sys/fs/cgroup/cpu/cig"
+type containerRow struct {
+	ID         string
+	Image      string
+	Command    string
+	CreatedAt  string
+	RunningFor string
+	Status     string
+	State      string
+	Size       string
+	Names      string
+	Labels     string
+}
 
-	file, err := os.Open(basePath + "/" + containerID + "/cgroup.procs")
-	if err != nil {
-		fmt.Println("Unable to read cgroup.procs")
-		return container, err
+var containerColumns = []format.Column{
+	{Header: "CONTAINER ID", Field: "ID"},
+	{Header: "IMAGE", Field: "Image"},
+	{Header: "COMMAND", Field: "Command"},
+	{Header: "CREATED", Field: "RunningFor"},
+	{Header: "STATUS", Field: "Status"},
+	{Header: "SIZE", Field: "Size"},
+	{Header: "NAMES", Field: "Names"},
+}
+
+var containerFilters = []string{"status", "name", "label", "ancestor", "since", "before", "exited"}
+
+func newContainerRow(state *utils.ContainerState, noTrunc bool) containerRow {
+	/* Storage is only mounted while the container runs */
+	size := "-"
+	if container.IsContainerRunning(state) {
+		if used, limit, ok := container.GetStorageUsage(state.ID); ok {
+			size = utils.HumanSize(used) + " / " + utils.HumanSize(limit)
+		}
 	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		procs = append(procs, scanner.Text())
+	var labels []string
+	for key, value := range state.Labels {
+		labels = append(labels, key+"="+value)
 	}
-	if len(procs) > 0 {
-		pid, err := strconv.Atoi(procs[len(procs)-1])
-		if err != nil {
-			fmt.Println("Unable to read PID")
-			return container, err
-		}
-		cmd, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
-		containerMntPath := utils.GetCigContainersPath() + "/" + containerID + "/fs/mnt"
-		realContainerMntPath, err := filepath.EvalSymlinks(containerMntPath)
-		if err != nil {
-			fmt.Println("Unable to resolve path")
-			return container, err
-		}
+	sort.Strings(labels)
+	command := strings.Join(state.Args, " ")
+	if !noTrunc && len(command) > 20 {
+		command = command[:19] + "…"
+	}
+	return containerRow{
+		ID:         format.Truncate(state.ID, 12, noTrunc),
+		Image:      state.Image,
+		Command:    strconv.Quote(command),
+		CreatedAt:  state.Created.Format(time.RFC3339),
+		RunningFor: utils.HumanDuration(time.Since(state.Created)) + " ago",
+		Status:     describeContainerStatus(state),
+		State:      getContainerStateName(state),
+		Size:       size,
+		Names:      state.Name,
+		Labels:     strings.Join(labels, ","),
+	}
+}
 
+func matchContainer(state *utils.ContainerState, filters format.Filters) bool {
+	createdOf := func(ref string) time.Time {
+		containerID, err := container.ResolveContainerID(ref)
 		if err != nil {
-			fmt.Println("Unable to read command link.")
-			return container, err
+			utils.Fatalf("%v", err)
 		}
-		image, _ := getDistribution(containerID)
-		container = utils.RunningContainerInfo{
-			ContainerId: containerID,
-			Image:       image,
-			Command:     cmd[len(realContainerMntPath):],
-			Pid:         pid,
+		other, err := container.LoadContainerState(containerID)
+		if err != nil {
+			utils.Fatalf("%v", err)
 		}
+		return other.Created
 	}
-	return container, nil
+	return filters.Match("status", func(value string) bool {
+		return getContainerStateName(state) == value
+	}) && filters.Match("name", func(value string) bool {
+		return strings.Contains(state.Name, value)
+	}) && filters.MatchLabels(state.Labels) && filters.Match("ancestor", func(value string) bool {
+		imageShaHex, _ := image.GetImageHashForRef(value)
+		return value == state.Image || imageShaHex == state.ImageID
+	}) && filters.Match("since", func(value string) bool {
+		return state.Created.After(createdOf(value))
+	}) && filters.Match("before", func(value string) bool {
+		return state.Created.Before(createdOf(value))
+	}) && filters.Match("exited", func(value string) bool {
+		code, err := strconv.Atoi(value)
+		return err == nil && state.Status == utils.StatusExited && state.ExitCode == code
+	})
 }
 
-func getDistribution(containerID string) (string, error) {
-	var lines []string
-	file, err := os.Open("/proc/mounts")
+/*
+	List containers, newest first: only running ones unless all is set or
+	a filter asks for others.
+*/
+
+func PrintContainers(all bool, filterArgs []string, opts format.Options) {
+	filters, err := format.ParseFilters(filterArgs, containerFilters...)
 	if err != nil {
-		fmt.Println("Unable to read /proc/mounts")
-		return "", err
+		utils.Fatalf("%v", err)
 	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	_, byStatus := filters["status"]
+	_, byExitCode := filters["exited"]
+	containers, err := container.ListContainerStates()
+	if err != nil {
+		utils.Fatalf("Unable to list containers: %v", err)
 	}
-
-	for _, line := range lines {
-		if strings.Contains(line, containerID) {
-			parts := strings.Split(line, " ")
-			for _, part := range parts {
-				if strings.Contains(part, "lowerdir=") {
-					options := strings.Split(part, ",")
-					for _, option := range options {
-						if strings.Contains(option, "lowerdir=") {
-							imagesPath := utils.GetCigImagesPath()
-							leaderString := "lowerdir=" + imagesPath + "/"
-							trailerString := option[len(leaderString):]
-							imageID := trailerString[:12]
-							image, tag := image.GetImageAndTagForHash(imageID)
-							return fmt.Sprintf("%s:%s", image, tag), nil
-						}
-					}
-				}
-			}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Created.After(containers[j].Created)
+	})
+	var rows []interface{}
+	for _, state := range containers {
+		if !all && !byStatus && !byExitCode && !container.IsContainerRunning(state) {
+			continue
+		}
+		if matchContainer(state, filters) {
+			rows = append(rows, newContainerRow(state, opts.NoTrunc))
 		}
 	}
-	return "", nil
-}
-
-func PrintRunningContainers() {
-	containers, err := getRunningContainers()
-	if err != nil {
-		os.Exit(1)
-	}
-
-	fmt.Println("CONTAINER ID\tIMAGE\t\tCOMMAND")
-	for _, container := range containers {
-		fmt.Printf("%s\t%s\t%s\n", container.ContainerId, container.Image, container.Command)
+	if err := format.Print(rows, containerColumns, opts); err != nil {
+		utils.Fatalf("Unable to format containers: %v", err)
 	}
 }
 
@@ -152,22 +182,24 @@ func DeleteImageByHash(imageShaHex string) {
 	// Ensure that no running container is using the image we're setting
 	// out to delete. There is a race condition possible here, but we use
 	// the ostrich algorithm
-	imgName, imgTag := image.GetImageAndTagForHash(imageShaHex)
+	imgName, _ := image.GetImageAndTagForHash(imageShaHex)
 	if len(imgName) == 0 {
-		log.Fatalf("No such image")
+		utils.Fatalf("No such image")
 	}
-	containers, err := getRunningContainers()
+	containers, err := container.ListContainerStates()
 	if err != nil {
-		log.Fatalf("Unable to get running containers list: %v\n", err)
+		utils.Fatalf("Unable to get containers list: %v\n", err)
 	}
 	for _, container := range containers {
-		if container.Image == imgName + ":" + imgTag {
-			log.Fatalf("Cannot delete image becuase it is in use by: %s",
-						container.ContainerId)
+		if container.ImageID == imageShaHex {
+			utils.Fatalf("Cannot delete image becuase it is in use by: %s",
+				container.ID)
 		}
 	}
 
-	utils.LogErrWithMsg(os.RemoveAll(utils.GetCigImagesPath() + "/" + imageShaHex),
+	image.RemoveLayerLinks(imageShaHex)
+	utils.LogErrWithMsg(os.RemoveAll(utils.GetCigImagesPath()+"/"+imageShaHex),
 		"Unable to remove image directory")
 	image.RemoveImageMetadata(imageShaHex)
+	events.Log(events.TypeImage, "delete", imageShaHex, map[string]string{"name": imgName})
 }
@@ -0,0 +1,163 @@
+package exec
+
+import (
+	"ContainInGo/container"
+	"ContainInGo/format"
+	"ContainInGo/utils"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const statsInterval = time.Second
+
+/*
+	A line of cig stats. The numbers are there for JSON output and
+	templates, the table shows the strings.
+*/
+
+type statsRow struct {
+	ID          string
+	Name        string
+	CPUPerc     string
+	MemUsage    string
+	MemPerc     string
+	NetIO       string
+	BlockIO     string
+	PIDs        string
+	Read        time.Time
+	CPUPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	PidsCurrent uint64
+	PidsLimit   uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+	NetRx       uint64
+	NetTx       uint64
+}
+
+var statsColumns = []format.Column{
+	{Header: "CONTAINER ID", Field: "ID"},
+	{Header: "NAME", Field: "Name"},
+	{Header: "CPU %", Field: "CPUPerc"},
+	{Header: "MEM USAGE / LIMIT", Field: "MemUsage"},
+	{Header: "MEM %", Field: "MemPerc"},
+	{Header: "NET I/O", Field: "NetIO"},
+	{Header: "BLOCK I/O", Field: "BlockIO"},
+	{Header: "PIDS", Field: "PIDs"},
+}
+
+/*
+	CPU % is the CPU time used between two samples over the time between
+	them, so a container keeping two cores busy is at 200%.
+*/
+
+func newStatsRow(state *utils.ContainerState, prev container.ContainerStats, cur container.ContainerStats) statsRow {
+	var cpuPercent, memPercent float64
+	if elapsed := cur.Read.Sub(prev.Read); elapsed > 0 && cur.CPUUsage >= prev.CPUUsage {
+		cpuPercent = float64(cur.CPUUsage-prev.CPUUsage) / float64(elapsed.Nanoseconds()) * 100
+	}
+	if cur.MemoryLimit > 0 {
+		memPercent = float64(cur.MemoryUsage) / float64(cur.MemoryLimit) * 100
+	}
+	pids := strconv.FormatUint(cur.PidsCurrent, 10)
+	if cur.PidsLimit > 0 {
+		pids += " / " + strconv.FormatUint(cur.PidsLimit, 10)
+	}
+	size := func(bytes uint64) string {
+		return utils.HumanSize(int64(bytes))
+	}
+	return statsRow{
+		ID:          format.Truncate(state.ID, 12, false),
+		Name:        state.Name,
+		CPUPerc:     fmt.Sprintf("%.2f%%", cpuPercent),
+		MemUsage:    size(cur.MemoryUsage) + " / " + size(cur.MemoryLimit),
+		MemPerc:     fmt.Sprintf("%.2f%%", memPercent),
+		NetIO:       size(cur.NetRx) + " / " + size(cur.NetTx),
+		BlockIO:     size(cur.BlockRead) + " / " + size(cur.BlockWrite),
+		PIDs:        pids,
+		Read:        cur.Read,
+		CPUPercent:  cpuPercent,
+		MemoryUsage: cur.MemoryUsage,
+		MemoryLimit: cur.MemoryLimit,
+		PidsCurrent: cur.PidsCurrent,
+		PidsLimit:   cur.PidsLimit,
+		BlockRead:   cur.BlockRead,
+		BlockWrite:  cur.BlockWrite,
+		NetRx:       cur.NetRx,
+		NetTx:       cur.NetTx,
+	}
+}
+
+/*
+	The running containers to sample: those asked for, or all of them
+*/
+
+func getStatsContainers(containerIDs []string) []*utils.ContainerState {
+	var states []*utils.ContainerState
+	if len(containerIDs) == 0 {
+		all, err := container.ListContainerStates()
+		if err != nil {
+			utils.Fatalf("Unable to list containers: %v", err)
+		}
+		for _, state := range all {
+			if container.IsContainerRunning(state) {
+				states = append(states, state)
+			}
+		}
+		return states
+	}
+	for _, containerID := range containerIDs {
+		if state, err := getRunningContainerInfoForId(containerID); err == nil {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+/*
+	Show the resource usage of containers, refreshed every second until
+	interrupted, or once with noStream. With the json format every refresh
+	prints one line per container, for feeding to other programs.
+*/
+
+func PrintContainerStats(containerIDs []string, noStream bool, opts format.Options) {
+	/* Clear the screen between tables, unless the output goes elsewhere */
+	clearScreen := (opts.Format == "table" || len(opts.Format) == 0) && utils.IsTerminal(int(os.Stdout.Fd()))
+	samples := map[string]container.ContainerStats{}
+	for {
+		var rows []interface{}
+		started := false
+		current := map[string]container.ContainerStats{}
+		for _, state := range getStatsContainers(containerIDs) {
+			cur, err := container.GetContainerStats(state)
+			if err != nil {
+				continue
+			}
+			/* A container's first sample waits for the next one to compute rates */
+			if prev, ok := samples[state.ID]; ok {
+				rows = append(rows, newStatsRow(state, prev, cur))
+			} else {
+				started = true
+			}
+			current[state.ID] = cur
+		}
+		samples = current
+		if started && len(rows) == 0 {
+			time.Sleep(statsInterval)
+			continue
+		}
+		if clearScreen {
+			fmt.Print("\033[2J\033[H")
+		}
+		if err := format.Print(rows, statsColumns, opts); err != nil {
+			utils.Fatalf("Unable to format stats: %v", err)
+		}
+		if noStream {
+			return
+		}
+		time.Sleep(statsInterval)
+	}
+}
@@ -0,0 +1,221 @@
+package exec
+
+import (
+	"ContainInGo/container"
+	"ContainInGo/format"
+	"ContainInGo/utils"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	Clock ticks per second that /proc/<pid>/stat counts CPU time in. It is
+	100 on every Linux architecture we run on.
+*/
+
+const clockTicks = 100
+
+type processRow struct {
+	UID     string
+	User    string
+	PID     string
+	PPID    string
+	NSPID   string
+	State   string
+	Time    string
+	RSS     string
+	VSZ     string
+	Comm    string
+	Command string
+}
+
+/* The ps-style fields top can show, and the columns for them */
+var processColumns = map[string]format.Column{
+	"uid":   {Header: "UID", Field: "UID"},
+	"user":  {Header: "USER", Field: "User"},
+	"pid":   {Header: "PID", Field: "PID"},
+	"ppid":  {Header: "PPID", Field: "PPID"},
+	"nspid": {Header: "NSPID", Field: "NSPID"},
+	"stat":  {Header: "STAT", Field: "State"},
+	"time":  {Header: "TIME", Field: "Time"},
+	"rss":   {Header: "RSS", Field: "RSS"},
+	"vsz":   {Header: "VSZ", Field: "VSZ"},
+	"comm":  {Header: "COMMAND", Field: "Comm"},
+	"cmd":   {Header: "CMD", Field: "Command"},
+}
+
+var processFieldAliases = map[string]string{
+	"args": "cmd", "command": "cmd", "state": "stat", "s": "stat", "cputime": "time",
+	"ucmd": "comm", "euser": "user", "cpid": "nspid",
+}
+
+const defaultProcessFields = "user,pid,nspid,time,rss,cmd"
+
+/*
+	Parse ps-style field lists, e.g. "pid,rss" or "-o pid -o rss", into the
+	columns to show
+*/
+
+func parseProcessFields(args []string) ([]format.Column, error) {
+	var columns []format.Column
+	for _, arg := range args {
+		if arg == "-o" {
+			continue
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(arg, "-o"), ",") {
+			field = strings.ToLower(strings.TrimSpace(field))
+			if len(field) == 0 {
+				continue
+			}
+			if alias, ok := processFieldAliases[field]; ok {
+				field = alias
+			}
+			column, ok := processColumns[field]
+			if !ok {
+				return nil, fmt.Errorf("unknown field: %s", field)
+			}
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+/*
+	Parse /proc/<pid>/status into its keys and values
+*/
+
+func readProcStatus(pid int) (map[string]string, error) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 {
+			status[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	return status, nil
+}
+
+/*
+	The CPU time, user and system, the process has used, as ps shows it:
+	[DD-]HH:MM:SS
+*/
+
+func readProcCPUTime(pid int) (string, error) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", err
+	}
+	/* The command name may contain spaces, the fields after it don't */
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 13 {
+		return "", fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	secs := (utime + stime) / clockTicks
+	days, hms := secs/86400, fmt.Sprintf("%02d:%02d:%02d", secs%86400/3600, secs%3600/60, secs%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, hms), nil
+	}
+	return hms, nil
+}
+
+func firstField(value string) string {
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func lastField(value string) string {
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[len(fields)-1]
+	}
+	return ""
+}
+
+func newProcessRow(pid int) (processRow, error) {
+	status, err := readProcStatus(pid)
+	if err != nil {
+		return processRow{}, err
+	}
+	cpuTime, err := readProcCPUTime(pid)
+	if err != nil {
+		return processRow{}, err
+	}
+	cmdline, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return processRow{}, err
+	}
+	/* Real UID, and the PID in the innermost namespace, the container's */
+	uid := firstField(status["Uid"])
+	userName := uid
+	if u, err := user.LookupId(uid); err == nil {
+		userName = u.Username
+	}
+	command := strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	if len(command) == 0 {
+		command = "[" + status["Name"] + "]"
+	}
+	kb := func(value string) string {
+		if len(value) == 0 {
+			return "0"
+		}
+		return firstField(value)
+	}
+	return processRow{
+		UID:     uid,
+		User:    userName,
+		PID:     strconv.Itoa(pid),
+		PPID:    status["PPid"],
+		NSPID:   lastField(status["NSpid"]),
+		State:   firstField(status["State"]),
+		Time:    cpuTime,
+		RSS:     kb(status["VmRSS"]),
+		VSZ:     kb(status["VmSize"]),
+		Comm:    status["Name"],
+		Command: command,
+	}, nil
+}
+
+/*
+	List the processes running in a container, taken from its cgroup, with
+	the given ps-style fields
+*/
+
+func PrintContainerProcesses(containerID string, fieldArgs []string) {
+	if _, err := getRunningContainerInfoForId(containerID); err != nil {
+		utils.Fatalf("%v", err)
+	}
+	if len(fieldArgs) == 0 {
+		fieldArgs = []string{defaultProcessFields}
+	}
+	columns, err := parseProcessFields(fieldArgs)
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	pids, err := container.GetCGroupPids(containerID)
+	if err != nil {
+		utils.Fatalf("Unable to list container processes: %v", err)
+	}
+	sort.Ints(pids)
+	var rows []interface{}
+	for _, pid := range pids {
+		/* Processes that exit while we look are left out */
+		if row, err := newProcessRow(pid); err == nil {
+			rows = append(rows, row)
+		}
+	}
+	if err := format.Print(rows, columns, format.Options{Format: "table"}); err != nil {
+		utils.Fatalf("Unable to format processes: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	--filter key=value predicates. Values given for the same key are
+	alternatives, different keys must all match.
+*/
+
+type Filters map[string][]string
+
+func ParseFilters(args []string, allowed ...string) (Filters, error) {
+	filters := Filters{}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad filter format: %s, expected key=value", arg)
+		}
+		key := strings.ToLower(kv[0])
+		valid := false
+		for _, name := range allowed {
+			valid = valid || name == key
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid filter: %s", key)
+		}
+		filters[key] = append(filters[key], kv[1])
+	}
+	return filters, nil
+}
+
+/*
+	Whether any of the values of the filter key satisfy match. Without a
+	filter for key, everything does.
+*/
+
+func (f Filters) Match(key string, match func(value string) bool) bool {
+	values, ok := f[key]
+	if !ok {
+		return true
+	}
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Match labels against label filters, given as key or key=value
+*/
+
+func (f Filters) MatchLabels(labels map[string]string) bool {
+	for _, filter := range f["label"] {
+		kv := strings.SplitN(filter, "=", 2)
+		value, ok := labels[kv[0]]
+		if !ok || len(kv) == 2 && value != kv[1] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	flag "github.com/spf13/pflag"
+)
+
+/*
+	Output of list commands. Every list command describes its rows as
+	structs and its table as columns, and gets the same choice of output:
+		table             the default table, aligned
+		table <template>  a table of the given columns
+		json              one JSON object per row
+		<template>        each row through a Go template
+	Quiet prints only the IDs. Truncating long values is up to the command,
+	as it builds the rows.
+*/
+
+type Options struct {
+	Format  string
+	Quiet   bool
+	NoTrunc bool
+}
+
+type Column struct {
+	Header string
+	/* Field of the row struct, e.g. ID */
+	Field string
+}
+
+func tableTemplate(columns []Column) string {
+	var fields []string
+	for _, column := range columns {
+		fields = append(fields, "{{."+column.Field+"}}")
+	}
+	return strings.Join(fields, "\t")
+}
+
+/*
+	Parse a Go template for output, with json and join available to it
+*/
+
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("format").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"join": strings.Join,
+	}).Parse(text)
+}
+
+/*
+	Write rows to os.Stdout the way opts asks for
+*/
+
+func Print(rows []interface{}, columns []Column, opts Options) error {
+	return Write(os.Stdout, rows, columns, opts)
+}
+
+func Write(out io.Writer, rows []interface{}, columns []Column, opts Options) error {
+	text := opts.Format
+	switch {
+	case opts.Quiet:
+		text = "{{.ID}}"
+	case text == "json":
+		for _, row := range rows {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, string(data))
+		}
+		return nil
+	case len(text) == 0 || text == "table":
+		text = "table " + tableTemplate(columns)
+	}
+
+	table := strings.HasPrefix(text, "table ")
+	text = strings.TrimPrefix(text, "table ")
+	/* Let \t in a format given on the command line separate columns */
+	text = strings.ReplaceAll(text, `\t`, "\t")
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	var tw *tabwriter.Writer
+	if table {
+		tw = tabwriter.NewWriter(out, 10, 1, 3, ' ', 0)
+		out = tw
+		/* Headers come from running the template on the column headers */
+		headers := map[string]string{}
+		for _, column := range columns {
+			headers[column.Field] = column.Header
+		}
+		if err := tmpl.Execute(out, headers); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	for _, row := range rows {
+		if err := tmpl.Execute(out, row); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	if tw != nil {
+		return tw.Flush()
+	}
+	return nil
+}
+
+/*
+	Shorten s to n characters unless noTrunc is set
+*/
+
+func Truncate(s string, n int, noTrunc bool) string {
+	if noTrunc || len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+/*
+	Register the flags every list command takes
+*/
+
+func AddFlags(fs *flag.FlagSet, opts *Options, filters *[]string) {
+	fs.StringVar(&opts.Format, "format", "table", "Output format: table, json, or a Go template")
+	fs.BoolVarP(&opts.Quiet, "quiet", "q", false, "Only show IDs")
+	fs.BoolVar(&opts.NoTrunc, "no-trunc", false, "Don't truncate output")
+	fs.StringArrayVarP(filters, "filter", "f", nil, "Filter output based on conditions, e.g. status=running")
+}
@@ -1,6 +1,6 @@
 module ContainInGo
 
-go 1.17
+go 1.18
 
 require (
 	github.com/spf13/pflag v1.0.5
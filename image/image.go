@@ -1,12 +1,13 @@
 package image
 
 import (
+	"ContainInGo/events"
 	"ContainInGo/utils"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/crane"
@@ -25,6 +26,26 @@ func GetConfigPathForImage(imageShaHex string) string {
 	return GetBasePathForImage(imageShaHex) + "/" + imageShaHex + ".json"
 }
 
+/*
+	Return the directories of the image's extracted layers, lowest layer first
+*/
+
+func GetLayerDirsForImage(imageShaHex string) []string {
+	var layerDirs []string
+	mani := utils.Manifest{}
+	utils.ParseManifest(GetManifestPathForImage(imageShaHex), &mani)
+	if len(mani) == 0 || len(mani[0].Layers) == 0 {
+		utils.Fatal("Could not find any layers.")
+	}
+	if len(mani) > 1 {
+		utils.Fatal("I don't know how to handle more than one manifest.")
+	}
+	for _, layer := range mani[0].Layers {
+		layerDirs = append(layerDirs, GetBasePathForImage(imageShaHex)+"/"+layer[:12])
+	}
+	return layerDirs
+}
+
 /*
 	Parse Image name and tag name from source
 	Example : alphine:latest
@@ -56,10 +77,10 @@ func parseImagesMetadata(idb *utils.ImagesDB) {
 	}
 	data, err := ioutil.ReadFile(imagesDBPath)
 	if err != nil {
-		log.Fatalf("Could not read images DB: %v\n", err)
+		utils.Fatalf("Could not read images DB: %v\n", err)
 	}
 	if err := json.Unmarshal(data, idb); err != nil {
-		log.Fatalf("Unable to parse images DB: %v\n", err)
+		utils.Fatalf("Unable to parse images DB: %v\n", err)
 	}
 }
 
@@ -99,14 +120,46 @@ func ImageExistByTag(imgName string, tagName string) (bool, string) {
 	return false, ""
 }
 
+/*
+* Resolve an image reference, either name[:tag] or image hash, to the image hash.
+ */
+
+func GetImageHashForRef(ref string) (string, bool) {
+	if imgName, _ := imageExistsByHash(ref); len(imgName) > 0 {
+		return ref, true
+	}
+	imgName, tagName := getImageNameAndTag(ref)
+	exists, imageShaHex := ImageExistByTag(imgName, tagName)
+	return imageShaHex, exists
+}
+
+/*
+* Return every name:tag that refers to the image hash.
+ */
+
+func GetImageRefsForHash(imageShaHex string) []string {
+	var refs []string
+	idb := utils.ImagesDB{}
+	parseImagesMetadata(&idb)
+	for imgName, avlImages := range idb {
+		for imgTag, imgHash := range avlImages {
+			if imgHash == imageShaHex {
+				refs = append(refs, imgName+":"+imgTag)
+			}
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
 func marshalImageMetadata(idb utils.ImagesDB) {
 	fileBytes, err := json.Marshal(idb)
 	if err != nil {
-		log.Fatalf("Unable to marshall images data: %v\n", err)
+		utils.Fatalf("Unable to marshall images data: %v\n", err)
 	}
 	imagesDBPath := utils.GetCigImagesPath() + "/" + "images.json"
 	if err := ioutil.WriteFile(imagesDBPath, fileBytes, 0644); err != nil {
-		log.Fatalf("Unable to save images DB: %v\n", err)
+		utils.Fatalf("Unable to save images DB: %v\n", err)
 	}
 }
 
@@ -138,7 +191,7 @@ func downloadImage(img v1.Image, imageShaHex string, src string) {
 	path += "/package.tar"
 	/* Save the image as a tar file */
 	if err := crane.SaveLegacy(img, src, path); err != nil {
-		log.Fatalf("saving tarball %s: %v", path, err)
+		utils.Fatalf("saving tarball %s: %v", path, err)
 	}
 	log.Printf("Successfully downloaded %s\n", src)
 }
@@ -150,9 +203,15 @@ func DownloadImageIfRequired(src string) string {
 		log.Printf("Downloading metadata for %s:%s, please wait...", imgName, tagName)
 		img, err := crane.Pull(strings.Join([]string{imgName, tagName}, ":"))
 		if err != nil {
-			log.Fatal(err)
+			utils.Fatal(err)
 		}
 
+		digest, err := img.Digest()
+		if err != nil {
+			utils.Fatalf("Unable to get image digest: %v\n", err)
+		}
+		EnforceTrustPolicy(imgName, digest.String())
+
 		manifest, _ := img.Manifest()
 		imageShaHex = manifest.Config.Digest.Hex[:12]
 		log.Printf("imageHash: %v\n", imageShaHex)
@@ -162,14 +221,20 @@ func DownloadImageIfRequired(src string) string {
 		if len(altImgName) > 0 && len(altImgTag) > 0 {
 			log.Printf("The image you requested %s:%s is the same as %s:%s\n",
 				imgName, tagName, altImgName, altImgTag)
+			recordImageDigest(imageShaHex, imgName, tagName, digest.String())
 			storeImageMetadata(imgName, tagName, imageShaHex)
+			events.Log(events.TypeImage, "tag", imageShaHex, map[string]string{"name": imgName + ":" + tagName})
 			return imageShaHex
 		} else {
 			log.Println("Image doesn't exist. Downloading...")
 			downloadImage(img, imageShaHex, src)
 			untarFile(imageShaHex)
 			processLayerTarballs(imageShaHex, manifest.Config.Digest.Hex)
+			utils.LogErrWithMsg(ioutil.WriteFile(GetBasePathForImage(imageShaHex)+"/digest",
+				[]byte(digest.String()), 0644), "Unable to record image digest")
+			recordImageDigest(imageShaHex, imgName, tagName, digest.String())
 			storeImageMetadata(imgName, tagName, imageShaHex)
+			events.Log(events.TypeImage, "pull", imageShaHex, map[string]string{"name": imgName + ":" + tagName})
 			/*
 				Delete folder containing tarball of image
 			*/
@@ -192,11 +257,11 @@ func ParseContainerConfig(imageShaHex string) utils.ImageConfig {
 	data, err := ioutil.ReadFile(imagesConfigPath)
 	if err != nil {
 		utils.LogErr(err)
-		log.Fatalf("Could not read image config file")
+		utils.Fatalf("Could not read image config file")
 	}
 	imgConfig := utils.ImageConfig{}
 	if err := json.Unmarshal(data, &imgConfig); err != nil {
-		log.Fatalf("Unable to parse image config data!")
+		utils.Fatalf("Unable to parse image config data!")
 	}
 	return imgConfig
 }
@@ -214,25 +279,13 @@ func GetImageAndTagForHash(imageShaHash string) (string, string) {
 	return "", ""
 }
 
-func PrintAvailableImages() {
-	idb := utils.ImagesDB{}
-	parseImagesMetadata(&idb)
-	fmt.Printf("IMAGE\t             TAG\t   ID\n")
-	for image, details := range idb {
-		fmt.Printf("%s", image)
-		for tag, hash := range details {
-			fmt.Printf("\t%16s %s\n", tag, hash)
-		}
-	}
-}
-
 func RemoveImageMetadata(imageShaHex string) {
 	idb := utils.ImagesDB{}
 	ientries := utils.ImageEntries{}
 	parseImagesMetadata(&idb)
 	imgName, _ := imageExistsByHash(imageShaHex)
 	if len(imgName) == 0 {
-		log.Fatalf("Could not get image details")
+		utils.Fatalf("Could not get image details")
 	}
 	ientries = idb[imgName]
 	for tag, hash := range ientries {
@@ -0,0 +1,72 @@
+package image
+
+import (
+	"ContainInGo/utils"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const layerLinkChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+const layerLinkLength = 10
+
+/*
+	Layers are referred to in overlay mount options through short symlinks in
+	the layer links directory, so that deep images stay below the mount data
+	size limit. The name of a layer's symlink is stored in a "link" file next to
+	its fs directory.
+*/
+
+func GetLayerLink(layerDir string) (string, error) {
+	return getLayerLinkIn(utils.GetCigLayerLinksPath(), layerDir)
+}
+
+func getLayerLinkIn(linksPath string, layerDir string) (string, error) {
+	linkFile := layerDir + "/link"
+	if data, err := ioutil.ReadFile(linkFile); err == nil {
+		link := strings.TrimSpace(string(data))
+		if _, err := os.Lstat(linksPath + "/" + link); err == nil {
+			return link, nil
+		}
+		if err := os.Symlink(layerDir+"/fs", linksPath+"/"+link); err != nil {
+			return "", err
+		}
+		return link, nil
+	}
+
+	for {
+		link := createLayerLinkID()
+		err := os.Symlink(layerDir+"/fs", linksPath+"/"+link)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return link, ioutil.WriteFile(linkFile, []byte(link), 0644)
+	}
+}
+
+func createLayerLinkID() string {
+	randBytes := make([]byte, layerLinkLength)
+	rand.Read(randBytes)
+	for i, b := range randBytes {
+		randBytes[i] = layerLinkChars[int(b)%len(layerLinkChars)]
+	}
+	return string(randBytes)
+}
+
+/*
+	Remove the layer symlinks that point into the image's directory.
+*/
+
+func RemoveLayerLinks(imageShaHex string) {
+	linkFiles, _ := filepath.Glob(GetBasePathForImage(imageShaHex) + "/*/link")
+	for _, linkFile := range linkFiles {
+		if data, err := ioutil.ReadFile(linkFile); err == nil {
+			utils.RemoveLinkIfExists(utils.GetCigLayerLinksPath() + "/" + strings.TrimSpace(string(data)))
+		}
+	}
+}
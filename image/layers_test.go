@@ -0,0 +1,45 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayerLinks(t *testing.T) {
+	home := t.TempDir()
+	linksPath := home + "/l"
+	if err := os.MkdirAll(linksPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 128; i++ {
+		layerDir := filepath.Join(home, "images", "0123456789ab", string(rune('a'+i%26))+string(rune('a'+i/26)))
+		if err := os.MkdirAll(layerDir+"/fs", 0755); err != nil {
+			t.Fatal(err)
+		}
+		link, err := getLayerLinkIn(linksPath, layerDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(link) != layerLinkLength || seen[link] {
+			t.Fatalf("Link %q is not a new %d character name", link, layerLinkLength)
+		}
+		seen[link] = true
+		if target, err := os.Readlink(linksPath + "/" + link); err != nil || target != layerDir+"/fs" {
+			t.Fatalf("Link %s points to %q (%v), expected %s/fs", link, target, err, layerDir)
+		}
+
+		/* The same link is used again, and restored if it went missing */
+		if again, err := getLayerLinkIn(linksPath, layerDir); err != nil || again != link {
+			t.Fatalf("Got link %q (%v) for the layer again, expected %q", again, err, link)
+		}
+		os.Remove(linksPath + "/" + link)
+		if again, err := getLayerLinkIn(linksPath, layerDir); err != nil || again != link {
+			t.Fatalf("Got link %q (%v) for the layer after removing it, expected %q", again, err, link)
+		}
+		if _, err := os.Lstat(linksPath + "/" + link); err != nil {
+			t.Fatalf("Link %s was not restored: %v", link, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package image
+
+import (
+	"ContainInGo/format"
+	"ContainInGo/utils"
+	"path"
+	"sort"
+	"time"
+)
+
+type imageRow struct {
+	Repository   string
+	Tag          string
+	ID           string
+	Digest       string
+	CreatedAt    string
+	CreatedSince string
+}
+
+var imageColumns = []format.Column{
+	{Header: "REPOSITORY", Field: "Repository"},
+	{Header: "TAG", Field: "Tag"},
+	{Header: "IMAGE ID", Field: "ID"},
+	{Header: "CREATED", Field: "CreatedSince"},
+}
+
+var imageFilters = []string{"reference", "label", "since", "before"}
+
+/*
+	Print the images in the local store, one row per name:tag
+*/
+
+func PrintImages(filterArgs []string, opts format.Options) {
+	filters, err := format.ParseFilters(filterArgs, imageFilters...)
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	createdOf := func(ref string) time.Time {
+		imageShaHex, ok := GetImageHashForRef(ref)
+		if !ok {
+			utils.Fatalf("No such image: %s", ref)
+		}
+		return ParseContainerConfig(imageShaHex).Created
+	}
+
+	idb := utils.ImagesDB{}
+	parseImagesMetadata(&idb)
+	var rows []interface{}
+	var names []string
+	for imgName := range idb {
+		names = append(names, imgName)
+	}
+	sort.Strings(names)
+	for _, imgName := range names {
+		for tag, imageShaHex := range idb[imgName] {
+			imgConfig := ParseContainerConfig(imageShaHex)
+			match := filters.Match("reference", func(value string) bool {
+				nameMatch, _ := path.Match(value, imgName)
+				refMatch, _ := path.Match(value, imgName+":"+tag)
+				return nameMatch || refMatch
+			}) && filters.MatchLabels(imgConfig.Config.Labels) &&
+				filters.Match("since", func(value string) bool {
+					return imgConfig.Created.After(createdOf(value))
+				}) &&
+				filters.Match("before", func(value string) bool {
+					return imgConfig.Created.Before(createdOf(value))
+				})
+			if !match {
+				continue
+			}
+			row := imageRow{
+				Repository:   imgName,
+				Tag:          tag,
+				ID:           imageShaHex,
+				Digest:       GetImageDigest(imageShaHex),
+				CreatedSince: "N/A",
+			}
+			if !imgConfig.Created.IsZero() {
+				row.CreatedAt = imgConfig.Created.Format(time.RFC3339)
+				row.CreatedSince = utils.HumanDuration(time.Since(imgConfig.Created)) + " ago"
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err := format.Print(rows, imageColumns, opts); err != nil {
+		utils.Fatalf("Unable to format images: %v", err)
+	}
+}
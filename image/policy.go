@@ -0,0 +1,136 @@
+package image
+
+import (
+	"ContainInGo/utils"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+/*
+	The trust policy decides which images may be pulled and run. It lives in
+	policy.json in the cig home directory, e.g.
+	{
+		"default": {"type": "insecureAcceptAnything"},
+		"scopes": {
+			"docker.io": {"type": "reject"},
+			"docker.io/library/alpine": {"type": "signedBy", "keyPaths": ["/etc/cig/release.pub"]}
+		}
+	}
+	Scopes are a registry or a registry/repository. The most specific scope
+	matching an image applies, then the default. Without a policy file every
+	image is accepted.
+*/
+
+const (
+	policyAcceptAnything = "insecureAcceptAnything"
+	policyReject         = "reject"
+	policySignedBy       = "signedBy"
+)
+
+type policyRequirement struct {
+	Type     string   `json:"type"`
+	KeyPath  string   `json:"keyPath,omitempty"`
+	KeyPaths []string `json:"keyPaths,omitempty"`
+}
+
+type trustPolicy struct {
+	Default policyRequirement            `json:"default"`
+	Scopes  map[string]policyRequirement `json:"scopes"`
+}
+
+func parseTrustPolicy() (*trustPolicy, error) {
+	data, err := ioutil.ReadFile(utils.GetCigHomePath() + "/policy.json")
+	if os.IsNotExist(err) {
+		return &trustPolicy{Default: policyRequirement{Type: policyAcceptAnything}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy := trustPolicy{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	if len(policy.Default.Type) == 0 {
+		/* Be strict when the policy doesn't say */
+		policy.Default.Type = policyReject
+	}
+	return &policy, nil
+}
+
+/*
+	Return the policy scopes of an image name, most specific first
+*/
+
+func getPolicyScopes(imgName string) []string {
+	repo, err := name.NewRepository(imgName)
+	if err != nil {
+		return nil
+	}
+	registries := []string{repo.RegistryStr()}
+	if repo.RegistryStr() == name.DefaultRegistry {
+		registries = append(registries, "docker.io")
+	}
+	var scopes []string
+	for _, registry := range registries {
+		scopes = append(scopes, registry+"/"+repo.RepositoryStr())
+	}
+	return append(scopes, registries...)
+}
+
+func checkTrustPolicy(imgName string, digest string) error {
+	policy, err := parseTrustPolicy()
+	if err != nil {
+		return fmt.Errorf("unable to parse trust policy: %v", err)
+	}
+	requirement := policy.Default
+	for _, scope := range getPolicyScopes(imgName) {
+		if scopeRequirement, ok := policy.Scopes[scope]; ok {
+			requirement = scopeRequirement
+			break
+		}
+	}
+
+	switch requirement.Type {
+	case policyAcceptAnything:
+		return nil
+	case policyReject:
+		return fmt.Errorf("trust policy rejects %s", imgName)
+	case policySignedBy:
+		keyPaths := requirement.KeyPaths
+		if len(requirement.KeyPath) > 0 {
+			keyPaths = append(keyPaths, requirement.KeyPath)
+		}
+		if len(keyPaths) == 0 {
+			return fmt.Errorf("trust policy for %s has no keys", imgName)
+		}
+		return verifyImageSignatures(digest, keyPaths)
+	}
+	return fmt.Errorf("unknown trust policy requirement: %s", requirement.Type)
+}
+
+/*
+	Refuse to go on with an image the trust policy doesn't accept
+*/
+
+func EnforceTrustPolicy(imgName string, digest string) {
+	if err := checkTrustPolicy(imgName, digest); err != nil {
+		utils.Fatalf("Image %s (%s) not trusted: %v\n", imgName, digest, err)
+	}
+}
+
+/*
+	Enforce the trust policy on an image already in the store, which was
+	referred to as ref
+*/
+
+func EnforceTrustPolicyForImage(ref string, imageShaHex string) {
+	imgName, _ := getImageNameAndTag(ref)
+	if ref == imageShaHex {
+		imgName, _ = GetImageAndTagForHash(imageShaHex)
+	}
+	EnforceTrustPolicy(imgName, GetImageDigestForRef(ref, imageShaHex))
+}
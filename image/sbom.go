@@ -0,0 +1,439 @@
+package image
+
+import (
+	"ContainInGo/utils"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	sbomFormatSPDX      = "spdx-json"
+	sbomFormatCycloneDX = "cyclonedx"
+)
+
+const apkDBPath = "lib/apk/db/installed"
+const dpkgDBPath = "var/lib/dpkg/status"
+
+/*
+	A package found in an image, along with the layer that introduced it
+*/
+
+type sbomPackage struct {
+	Name     string
+	Version  string
+	Type     string
+	Arch     string
+	License  string
+	PURL     string
+	Layer    string
+	Location string
+}
+
+/*
+	Print a software bill of materials for the image ref in the given
+	format. Packages come from the apk and dpkg databases and from the build
+	information embedded in Go binaries, and are attributed to the layer
+	that introduced them.
+*/
+
+func PrintImageSBOM(ref string, format string) {
+	imageShaHex, exists := GetImageHashForRef(ref)
+	if !exists {
+		utils.Fatalf("No such image: %s\n", ref)
+	}
+	layerDirs := GetLayerDirsForImage(imageShaHex)
+	layerIDs := getLayerIDs(imageShaHex, layerDirs)
+	distro := getDistroID(layerDirs)
+
+	var pkgs []sbomPackage
+	pkgs = append(pkgs, scanPackageDB(layerDirs, layerIDs, apkDBPath, parseApkDB, distro)...)
+	pkgs = append(pkgs, scanPackageDB(layerDirs, layerIDs, dpkgDBPath, parseDpkgDB, distro)...)
+	pkgs = append(pkgs, scanGoBinaries(layerDirs, layerIDs)...)
+
+	var doc interface{}
+	switch format {
+	case sbomFormatSPDX:
+		doc = buildSPDXDocument(ref, pkgs)
+	case sbomFormatCycloneDX:
+		doc = buildCycloneDXDocument(ref, GetImageDigestForRef(ref, imageShaHex), pkgs)
+	default:
+		utils.Fatalf("Unknown SBOM format: %s\n", format)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		utils.Fatalf("Unable to marshall SBOM: %v\n", err)
+	}
+	fmt.Println(string(data))
+}
+
+/*
+	Layers are identified by their diff IDs when the image config has them
+*/
+
+func getLayerIDs(imageShaHex string, layerDirs []string) []string {
+	var layerIDs []string
+	if data, err := ioutil.ReadFile(GetConfigPathForImage(imageShaHex)); err == nil {
+		if cfg, err := v1.ParseConfigFile(bytes.NewReader(data)); err == nil &&
+			len(cfg.RootFS.DiffIDs) == len(layerDirs) {
+			for _, diffID := range cfg.RootFS.DiffIDs {
+				layerIDs = append(layerIDs, diffID.String())
+			}
+			return layerIDs
+		}
+	}
+	for _, layerDir := range layerDirs {
+		layerIDs = append(layerIDs, filepath.Base(layerDir))
+	}
+	return layerIDs
+}
+
+/*
+	Report whether the layer at layerDir hides relPath from the layers
+	below, either with a whiteout or an opaque parent directory.
+*/
+
+func layerHides(layerDir string, relPath string) bool {
+	for dir, name := filepath.Dir(relPath), filepath.Base(relPath); ; dir, name = filepath.Dir(dir), filepath.Base(dir) {
+		if _, err := os.Lstat(filepath.Join(layerDir, "fs", dir, WhiteoutPrefix+name)); err == nil {
+			return true
+		}
+		if _, err := os.Lstat(filepath.Join(layerDir, "fs", dir, WhiteoutOpaqueDir)); err == nil {
+			return true
+		}
+		if dir == "." {
+			return false
+		}
+	}
+}
+
+func getDistroID(layerDirs []string) string {
+	for i := len(layerDirs) - 1; i >= 0; i-- {
+		for _, relPath := range []string{"etc/os-release", "usr/lib/os-release"} {
+			file, err := os.Open(filepath.Join(layerDirs[i], "fs", relPath))
+			if err != nil {
+				continue
+			}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				if strings.HasPrefix(scanner.Text(), "ID=") {
+					file.Close()
+					return strings.Trim(scanner.Text()[len("ID="):], `"'`)
+				}
+			}
+			file.Close()
+		}
+	}
+	return ""
+}
+
+/*
+	Every layer that touches a package database carries a complete copy of
+	it. Walking the layers from the bottom, a package is attributed to the
+	first layer of the unbroken run of databases it appears in.
+*/
+
+func scanPackageDB(layerDirs []string, layerIDs []string, dbPath string,
+	parse func([]byte, string) []sbomPackage, distro string) []sbomPackage {
+	found := make(map[string]sbomPackage)
+	for i, layerDir := range layerDirs {
+		data, err := ioutil.ReadFile(filepath.Join(layerDir, "fs", dbPath))
+		if err != nil {
+			if layerHides(layerDir, dbPath) {
+				found = make(map[string]sbomPackage)
+			}
+			continue
+		}
+		current := make(map[string]sbomPackage)
+		for _, pkg := range parse(data, distro) {
+			key := pkg.Name + "@" + pkg.Version
+			if prev, ok := found[key]; ok {
+				current[key] = prev
+				continue
+			}
+			pkg.Layer = layerIDs[i]
+			pkg.Location = "/" + dbPath
+			current[key] = pkg
+		}
+		found = current
+	}
+	return sortedPackages(found)
+}
+
+/*
+	Parse an apk installed database, stanzas of "X:value" lines
+*/
+
+func parseApkDB(data []byte, distro string) []sbomPackage {
+	var pkgs []sbomPackage
+	if len(distro) == 0 {
+		distro = "alpine"
+	}
+	pkg := sbomPackage{Type: "apk"}
+	flush := func() {
+		if len(pkg.Name) > 0 {
+			pkg.PURL = "pkg:apk/" + distro + "/" + pkg.Name + "@" + pkg.Version
+			if len(pkg.Arch) > 0 {
+				pkg.PURL += "?arch=" + pkg.Arch
+			}
+			pkgs = append(pkgs, pkg)
+		}
+		pkg = sbomPackage{Type: "apk"}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		switch line[0] {
+		case 'P':
+			pkg.Name = line[2:]
+		case 'V':
+			pkg.Version = line[2:]
+		case 'A':
+			pkg.Arch = line[2:]
+		case 'L':
+			pkg.License = line[2:]
+		}
+	}
+	flush()
+	return pkgs
+}
+
+/*
+	Parse a dpkg status file, paragraphs of "Field: value" lines. Only
+	packages that are actually installed are reported.
+*/
+
+func parseDpkgDB(data []byte, distro string) []sbomPackage {
+	var pkgs []sbomPackage
+	if len(distro) == 0 {
+		distro = "debian"
+	}
+	fields := make(map[string]string)
+	flush := func() {
+		if len(fields["Package"]) > 0 && strings.HasSuffix(fields["Status"], " installed") {
+			pkg := sbomPackage{
+				Name:    fields["Package"],
+				Version: fields["Version"],
+				Arch:    fields["Architecture"],
+				Type:    "deb",
+			}
+			pkg.PURL = "pkg:deb/" + distro + "/" + pkg.Name + "@" + pkg.Version
+			if len(pkg.Arch) > 0 {
+				pkg.PURL += "?arch=" + pkg.Arch
+			}
+			pkgs = append(pkgs, pkg)
+		}
+		fields = make(map[string]string)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+			fields[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	flush()
+	return pkgs
+}
+
+/*
+	Find the executables visible in the final image and report the Go
+	modules built into them.
+*/
+
+func scanGoBinaries(layerDirs []string, layerIDs []string) []sbomPackage {
+	executables := make(map[string]int)
+	/* Only files from lower layers can be hidden by a layer's whiteouts */
+	removePath := func(relPath string, layer int) {
+		for path, i := range executables {
+			if i < layer && (relPath == "." || path == relPath || strings.HasPrefix(path, relPath+"/")) {
+				delete(executables, path)
+			}
+		}
+	}
+	for i, layerDir := range layerDirs {
+		layerFs := layerDir + "/fs"
+		filepath.Walk(layerFs, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(layerFs, path)
+			switch {
+			case info.Name() == WhiteoutOpaqueDir:
+				removePath(filepath.Dir(rel), i)
+			case strings.HasPrefix(info.Name(), WhiteoutPrefix):
+				removePath(filepath.Join(filepath.Dir(rel), info.Name()[len(WhiteoutPrefix):]), i)
+			case info.Mode().IsRegular() && info.Mode()&0111 != 0:
+				executables[rel] = i
+			case !info.IsDir():
+				delete(executables, rel)
+			}
+			return nil
+		})
+	}
+
+	found := make(map[string]sbomPackage)
+	for relPath, i := range executables {
+		info, err := buildinfo.ReadFile(filepath.Join(layerDirs[i], "fs", relPath))
+		if err != nil {
+			continue
+		}
+		modules := []debugModule{{info.Main.Path, info.Main.Version}}
+		for _, dep := range info.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			modules = append(modules, debugModule{dep.Path, dep.Version})
+		}
+		for _, mod := range modules {
+			if len(mod.path) == 0 {
+				continue
+			}
+			pkg := sbomPackage{
+				Name:     mod.path,
+				Version:  mod.version,
+				Type:     "golang",
+				PURL:     "pkg:golang/" + mod.path + "@" + mod.version,
+				Layer:    layerIDs[i],
+				Location: "/" + relPath,
+			}
+			found[pkg.Location+" "+pkg.Name+"@"+pkg.Version] = pkg
+		}
+	}
+	return sortedPackages(found)
+}
+
+type debugModule struct {
+	path    string
+	version string
+}
+
+func sortedPackages(found map[string]sbomPackage) []sbomPackage {
+	var keys []string
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pkgs []sbomPackage
+	for _, key := range keys {
+		pkgs = append(pkgs, found[key])
+	}
+	return pkgs
+}
+
+func createUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func buildSPDXDocument(ref string, pkgs []sbomPackage) map[string]interface{} {
+	var packages []map[string]interface{}
+	var relationships []map[string]interface{}
+	for i, pkg := range pkgs {
+		spdxID := "SPDXRef-Package-" + strconv.Itoa(i+1)
+		license := pkg.License
+		if len(license) == 0 {
+			license = "NOASSERTION"
+		}
+		packages = append(packages, map[string]interface{}{
+			"name":             pkg.Name,
+			"SPDXID":           spdxID,
+			"versionInfo":      pkg.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseConcluded": "NOASSERTION",
+			"licenseDeclared":  license,
+			"sourceInfo":       "found in " + pkg.Location + " of layer " + pkg.Layer,
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  pkg.PURL,
+			}},
+		})
+		relationships = append(relationships, map[string]interface{}{
+			"spdxElementId":      "SPDXRef-DOCUMENT",
+			"relationshipType":   "DESCRIBES",
+			"relatedSpdxElement": spdxID,
+		})
+	}
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              ref,
+		"documentNamespace": "https://github.com/LightYear22000/ContainInGo/spdx/" + ref + "-" + createUUID(),
+		"creationInfo": map[string]interface{}{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: cig"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+func buildCycloneDXDocument(ref string, digest string, pkgs []sbomPackage) map[string]interface{} {
+	var components []map[string]interface{}
+	for _, pkg := range pkgs {
+		component := map[string]interface{}{
+			"type":    "library",
+			"name":    pkg.Name,
+			"version": pkg.Version,
+			"purl":    pkg.PURL,
+			"properties": []map[string]string{
+				{"name": "cig:package:type", "value": pkg.Type},
+				{"name": "cig:layer", "value": pkg.Layer},
+				{"name": "cig:location", "value": pkg.Location},
+			},
+		}
+		if len(pkg.License) > 0 {
+			component["licenses"] = []map[string]string{{"expression": pkg.License}}
+		}
+		components = append(components, component)
+	}
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.4",
+		"serialNumber": "urn:uuid:" + createUUID(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools":     []map[string]string{{"name": "cig"}},
+			"component": map[string]interface{}{
+				"type":    "container",
+				"name":    ref,
+				"version": digest,
+			},
+		},
+		"components": components,
+	}
+}
@@ -0,0 +1,270 @@
+package image
+
+import (
+	"ContainInGo/utils"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+	Signatures are detached from images and kept in the signatures
+	directory, one directory per manifest digest:
+		signatures/sha256=<hex>/signature-1
+		signatures/sha256=<hex>/signature-2
+	This way signatures can be distributed ahead of a pull and checked
+	before an image is downloaded, without any network access.
+*/
+
+type imageSignature struct {
+	Digest    string `json:"digest"`
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	Signature string `json:"signature"`
+}
+
+func getSignaturesDir(digest string) string {
+	return utils.GetCigSignaturesPath() + "/" + strings.Replace(digest, ":", "=", 1)
+}
+
+/*
+	Return the manifest digest of an image. Images pulled from a registry
+	keep the digest of the registry manifest, images created locally use the
+	digest of their manifest.json.
+*/
+
+func GetImageDigest(imageShaHex string) string {
+	if data, err := ioutil.ReadFile(GetBasePathForImage(imageShaHex) + "/digest"); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	data, err := ioutil.ReadFile(GetManifestPathForImage(imageShaHex))
+	if err != nil {
+		utils.Fatalf("Unable to read manifest: %v\n", err)
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+/*
+	The same image can be pulled under several references whose registry
+	manifests differ, e.g. through a multi-platform index, so the digest
+	each reference resolved to is kept as well, in digests.json.
+*/
+
+func getImageDigestsPath(imageShaHex string) string {
+	return GetBasePathForImage(imageShaHex) + "/digests.json"
+}
+
+func recordImageDigest(imageShaHex string, imgName string, tagName string, digest string) {
+	digests := map[string]string{}
+	if data, err := ioutil.ReadFile(getImageDigestsPath(imageShaHex)); err == nil {
+		json.Unmarshal(data, &digests)
+	}
+	digests[imgName+":"+tagName] = digest
+	data, err := json.Marshal(digests)
+	if err != nil {
+		utils.Fatalf("Unable to marshall image digests: %v\n", err)
+	}
+	utils.LogErrWithMsg(ioutil.WriteFile(getImageDigestsPath(imageShaHex), data, 0644),
+		"Unable to record image digest")
+}
+
+/*
+	Return the manifest digest the image ref, a name[:tag] or the image's
+	hash, resolved to when it was pulled, or the image's digest if it was
+	created locally
+*/
+
+func GetImageDigestForRef(ref string, imageShaHex string) string {
+	imgName, tagName := getImageNameAndTag(ref)
+	if ref == imageShaHex {
+		imgName, tagName = GetImageAndTagForHash(imageShaHex)
+	}
+	digests := map[string]string{}
+	if data, err := ioutil.ReadFile(getImageDigestsPath(imageShaHex)); err == nil {
+		json.Unmarshal(data, &digests)
+	}
+	if digest, ok := digests[imgName+":"+tagName]; ok {
+		return digest
+	}
+	return GetImageDigest(imageShaHex)
+}
+
+/*
+	Sign the manifest digest of the image ref with the PEM encoded PKCS#8
+	ed25519 or ECDSA private key at keyPath.
+*/
+
+func SignImage(ref string, keyPath string) string {
+	imageShaHex, exists := GetImageHashForRef(ref)
+	if !exists {
+		utils.Fatalf("No such image: %s\n", ref)
+	}
+	key, err := readPrivateKey(keyPath)
+	if err != nil {
+		utils.Fatalf("Unable to read signing key: %v\n", err)
+	}
+	digest := GetImageDigestForRef(ref, imageShaHex)
+
+	sig := imageSignature{Digest: digest}
+	var signer crypto.Signer
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig.Algorithm = "ed25519"
+		signer = k
+	case *ecdsa.PrivateKey:
+		sig.Algorithm = "ecdsa-sha256"
+		signer = k
+	default:
+		utils.Fatalf("Unsupported signing key type: %T\n", key)
+	}
+	if sig.KeyID, err = getKeyID(signer.Public()); err != nil {
+		utils.Fatalf("Unable to encode public key: %v\n", err)
+	}
+	var raw []byte
+	if sig.Algorithm == "ed25519" {
+		raw, err = signer.Sign(rand.Reader, []byte(digest), crypto.Hash(0))
+	} else {
+		sum := sha256.Sum256([]byte(digest))
+		raw, err = signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		utils.Fatalf("Unable to sign image: %v\n", err)
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(raw)
+
+	sigDir := getSignaturesDir(digest)
+	utils.LogErrWithMsg(os.MkdirAll(sigDir, 0755), "Unable to create signature directory")
+	data, err := json.Marshal(sig)
+	if err != nil {
+		utils.Fatalf("Unable to marshall signature: %v\n", err)
+	}
+	for i := 1; ; i++ {
+		sigPath := sigDir + "/signature-" + strconv.Itoa(i)
+		file, err := os.OpenFile(sigPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		utils.LogErrWithMsg(err, "Unable to create signature file")
+		_, err = file.Write(data)
+		file.Close()
+		utils.LogErrWithMsg(err, "Unable to write signature file")
+		log.Printf("Signed %s (%s): %s\n", ref, digest, sigPath)
+		return sigPath
+	}
+}
+
+/*
+	Check that digest has a valid signature made by one of the public keys
+	at keyPaths.
+*/
+
+func verifyImageSignatures(digest string, keyPaths []string) error {
+	keys := make(map[string]crypto.PublicKey)
+	for _, keyPath := range keyPaths {
+		key, err := readPublicKey(keyPath)
+		if err != nil {
+			return fmt.Errorf("reading public key %s: %v", keyPath, err)
+		}
+		keyID, err := getKeyID(key)
+		if err != nil {
+			return err
+		}
+		keys[keyID] = key
+	}
+
+	sigPaths, _ := filepath.Glob(getSignaturesDir(digest) + "/signature-*")
+	for _, sigPath := range sigPaths {
+		data, err := ioutil.ReadFile(sigPath)
+		if err != nil {
+			continue
+		}
+		sig := imageSignature{}
+		if err := json.Unmarshal(data, &sig); err != nil || sig.Digest != digest {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+		if err != nil {
+			continue
+		}
+		switch key := keys[sig.KeyID].(type) {
+		case ed25519.PublicKey:
+			if sig.Algorithm == "ed25519" && ed25519.Verify(key, []byte(digest), raw) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			sum := sha256.Sum256([]byte(digest))
+			if sig.Algorithm == "ecdsa-sha256" && ecdsa.VerifyASN1(key, sum[:], raw) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no valid signature for %s by a trusted key", digest)
+}
+
+/*
+	Keys are identified by the digest of their DER encoded public key
+*/
+
+func getKeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readPEMBlock(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return block, nil
+}
+
+func readPrivateKey(path string) (crypto.PrivateKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type: %T", key)
+}
@@ -0,0 +1,293 @@
+package image
+
+import (
+	"ContainInGo/events"
+	"ContainInGo/utils"
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const WhiteoutPrefix = ".wh."
+const WhiteoutOpaqueDir = ".wh..wh..opq"
+
+/*
+	Squash the layers of the image src, starting at layer index fromLayer,
+	into a single layer and store the result as a new image tagged dst.
+	Layers below fromLayer are kept as they are. Returns the hash of the
+	new image.
+*/
+
+func SquashImage(src string, dst string, fromLayer int) string {
+	srcShaHex, exists := GetImageHashForRef(src)
+	if !exists {
+		utils.Fatalf("No such image: %s\n", src)
+	}
+
+	mani := utils.Manifest{}
+	if err := utils.ParseManifest(GetManifestPathForImage(srcShaHex), &mani); err != nil {
+		utils.Fatalf("Unable to read manifest: %v\n", err)
+	}
+	if len(mani) == 0 || len(mani[0].Layers) == 0 {
+		utils.Fatal("Could not find any layers.")
+	}
+	if len(mani) > 1 {
+		utils.Fatal("I don't know how to handle more than one manifest.")
+	}
+	layers := mani[0].Layers
+	if fromLayer < 0 || fromLayer >= len(layers) {
+		utils.Fatalf("Layer index %d out of range, image has %d layers\n", fromLayer, len(layers))
+	}
+
+	data, err := ioutil.ReadFile(GetConfigPathForImage(srcShaHex))
+	if err != nil {
+		utils.Fatalf("Could not read image config file: %v\n", err)
+	}
+	cfg, err := v1.ParseConfigFile(bytes.NewReader(data))
+	if err != nil {
+		utils.Fatalf("Unable to parse image config data: %v\n", err)
+	}
+
+	/* Merge the layers we are squashing into a scratch directory */
+	srcBasePath := GetBasePathForImage(srcShaHex)
+	mergedPath, err := ioutil.TempDir(utils.GetCigTempPath(), "squash-")
+	if err != nil {
+		utils.Fatalf("Unable to create squash directory: %v\n", err)
+	}
+	defer os.RemoveAll(mergedPath)
+	for _, layer := range layers[fromLayer:] {
+		log.Printf("Squashing layer: %s\n", layer[:12])
+		if err := ApplyLayer(srcBasePath+"/"+layer[:12]+"/fs", mergedPath, fromLayer > 0, true); err != nil {
+			utils.Fatalf("Unable to squash layer %s: %v\n", layer, err)
+		}
+	}
+	diffID, err := layerDiffID(mergedPath)
+	if err != nil {
+		utils.Fatalf("Unable to compute layer digest: %v\n", err)
+	}
+
+	squashedLayers := len(layers) - fromLayer
+	cfg.RootFS.Type = "layers"
+	if len(cfg.RootFS.DiffIDs) == len(layers) {
+		cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs[:fromLayer:fromLayer], diffID)
+	} else {
+		cfg.RootFS.DiffIDs = []v1.Hash{diffID}
+	}
+	cfg.History = append(keptHistory(cfg.History, fromLayer), v1.History{
+		Created:   v1.Time{Time: time.Now().UTC()},
+		CreatedBy: "cig image squash",
+		Comment:   "squashed " + strconv.Itoa(squashedLayers) + " layers of " + src,
+	})
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		utils.Fatalf("Unable to marshall image config: %v\n", err)
+	}
+	sum := sha256.Sum256(cfgBytes)
+	fullImageHex := hex.EncodeToString(sum[:])
+	imageShaHex := fullImageHex[:12]
+
+	dstName, dstTag := getImageNameAndTag(dst)
+	newLayers := append(append([]string{}, layers[:fromLayer]...), diffID.Hex+".tar")
+	imagesDir := GetBasePathForImage(imageShaHex)
+	if _, err := os.Stat(imagesDir); os.IsNotExist(err) {
+		utils.LogErrWithMsg(os.Mkdir(imagesDir, 0755), "Unable to create image directory")
+		for _, layer := range layers[:fromLayer] {
+			layerDir := imagesDir + "/" + layer[:12]
+			utils.LogErrWithMsg(os.MkdirAll(layerDir, 0755), "Unable to create layer directory")
+			utils.LogErrWithMsg(utils.CopyTree(srcBasePath+"/"+layer[:12]+"/fs", layerDir+"/fs", true),
+				"Unable to copy layer "+layer)
+		}
+		layerDir := imagesDir + "/" + diffID.Hex[:12]
+		utils.LogErrWithMsg(os.MkdirAll(layerDir, 0755), "Unable to create layer directory")
+		utils.LogErrWithMsg(os.Rename(mergedPath, layerDir+"/fs"), "Unable to move squashed layer")
+
+		newMani := utils.Manifest{{
+			Config:   "sha256:" + fullImageHex,
+			RepoTags: []string{dstName + ":" + dstTag},
+			Layers:   newLayers,
+		}}
+		maniBytes, err := json.Marshal(newMani)
+		if err != nil {
+			utils.Fatalf("Unable to marshall manifest: %v\n", err)
+		}
+		utils.LogErrWithMsg(ioutil.WriteFile(GetManifestPathForImage(imageShaHex), maniBytes, 0644),
+			"Unable to write manifest")
+		utils.LogErrWithMsg(ioutil.WriteFile(GetConfigPathForImage(imageShaHex), cfgBytes, 0644),
+			"Unable to write image config")
+	}
+	storeImageMetadata(dstName, dstTag, imageShaHex)
+	events.Log(events.TypeImage, "squash", imageShaHex, map[string]string{"name": dstName + ":" + dstTag, "source": src})
+	log.Printf("Squashed %d layers of %s into %s:%s\n", squashedLayers, src, dstName, dstTag)
+	return imageShaHex
+}
+
+/*
+	Return the history entries that belong to the first keptLayers layers,
+	including the empty layer entries in between.
+*/
+
+func keptHistory(history []v1.History, keptLayers int) []v1.History {
+	var kept []v1.History
+	if keptLayers == 0 {
+		return kept
+	}
+	seen := 0
+	for _, entry := range history {
+		if !entry.EmptyLayer {
+			if seen == keptLayers {
+				break
+			}
+			seen++
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+/*
+	Apply the extracted layer at layerDir on top of target. Whiteout files
+	remove what earlier layers put in target. When keepWhiteouts is set the
+	whiteout markers are written to target as well, so that they still hide
+	files in the layers below the squashed ones. Regular files are hard linked
+	when hardLink is set and copied otherwise.
+*/
+
+func ApplyLayer(layerDir string, target string, keepWhiteouts bool, hardLink bool) error {
+	/* First pass: deletions, so the order of entries in a directory doesn't matter */
+	err := filepath.Walk(layerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, WhiteoutPrefix) {
+			return nil
+		}
+		rel, _ := filepath.Rel(layerDir, path)
+		dstDir := filepath.Join(target, filepath.Dir(rel))
+		if name == WhiteoutOpaqueDir {
+			entries, err := ioutil.ReadDir(dstDir)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, entry := range entries {
+				if err := os.RemoveAll(filepath.Join(dstDir, entry.Name())); err != nil {
+					return err
+				}
+			}
+		} else {
+			if err := os.RemoveAll(filepath.Join(dstDir, name[len(WhiteoutPrefix):])); err != nil {
+				return err
+			}
+		}
+		if keepWhiteouts {
+			if err := os.MkdirAll(dstDir, 0755); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dstDir, name), []byte{}, 0644)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	/* Second pass: additions */
+	return filepath.Walk(layerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(layerDir, path)
+		if rel == "." || strings.HasPrefix(info.Name(), WhiteoutPrefix) {
+			return nil
+		}
+		dst := filepath.Join(target, rel)
+		marker := filepath.Join(filepath.Dir(dst), WhiteoutPrefix+info.Name())
+		_, markerErr := os.Lstat(marker)
+		if markerErr == nil {
+			/*
+				The entry was deleted by a squashed layer and is now re-added.
+				A re-added directory must still hide what the lower layers had.
+			*/
+			if err := os.Remove(marker); err != nil {
+				return err
+			}
+		}
+		if err := utils.CopyEntry(path, dst, info, hardLink); err != nil {
+			return err
+		}
+		if markerErr == nil && info.IsDir() {
+			return ioutil.WriteFile(filepath.Join(dst, WhiteoutOpaqueDir), []byte{}, 0644)
+		}
+		return nil
+	})
+}
+
+/*
+	Compute the diff ID of the layer extracted at dir, which is the digest
+	of its uncompressed tar stream.
+*/
+
+func layerDiffID(dir string) (v1.Hash, error) {
+	hasher := sha256.New()
+	tw := tar.NewWriter(hasher)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "." {
+			return nil
+		}
+		linkTarget := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			header.Uid, header.Gid = int(stat.Uid), int(stat.Gid)
+		}
+		header.Uname, header.Gname = "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return v1.Hash{}, err
+	}
+	return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
@@ -19,7 +19,7 @@ func untarFile(imageShaHex string) {
 	pathDir := utils.GetCigTempPath() + "/" + imageShaHex
 	pathTar := pathDir + "/package.tar"
 	if err := untar(pathTar, pathDir); err != nil {
-		log.Fatalf("Error untaring file: %v\n", err)
+		utils.Fatalf("Error untaring file: %v\n", err)
 	}
 }
 
@@ -123,10 +123,10 @@ func processLayerTarballs(imageShaHex string, fullImageHex string) {
 	mani := utils.Manifest{}
 	utils.ParseManifest(pathManifest, &mani)
 	if len(mani) == 0 || len(mani[0].Layers) == 0 {
-		log.Fatal("Could not find any layers.")
+		utils.Fatal("Could not find any layers.")
 	}
 	if len(mani) > 1 {
-		log.Fatal("I don't know how to handle more than one manifest.")
+		utils.Fatal("I don't know how to handle more than one manifest.")
 	}
 
 	imagesDir := utils.GetCigImagesPath() + "/" + imageShaHex
@@ -138,7 +138,7 @@ func processLayerTarballs(imageShaHex string, fullImageHex string) {
 		_ = os.MkdirAll(imageLayerDir, 0755)
 		srcLayer := tmpPathDir + "/" + layer
 		if err := untar(srcLayer, imageLayerDir); err != nil {
-			log.Fatalf("Unable to untar layer file: %s: %v\n", srcLayer, err)
+			utils.Fatalf("Unable to untar layer file: %s: %v\n", srcLayer, err)
 		}
 	}
 	/* Copy the manifest file for reference later */
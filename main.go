@@ -1,6 +1,7 @@
 package main
 
 import (
+	"ContainInGo/format"
 	"ContainInGo/image"
 	net "ContainInGo/network"
 	"ContainInGo/utils"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	"ContainInGo/container"
@@ -19,20 +21,68 @@ import (
 func usage() {
 	fmt.Println("Welcome to ContainInGo!")
 	fmt.Println("Supported commands:")
-	fmt.Println("cig run [--mem] [--swap] [--pids] [--cpus] <image> <command>")
-	fmt.Println("cig exec <container-id> <command>")
-	fmt.Println("cig images")
+	fmt.Println("cig run [-d] [-i] [-t] [--rm] [--name <name>] [--restart <policy>] [--init] [--label key=value] [--mem] [--swap] [--pids] [--cpus] [--storage-opt size=<size>] [--log-opt max-size=<size>,max-file=<n>] <image> <command>")
+	fmt.Println("cig exec [-i] [-t] <container> <command>")
+	fmt.Println("cig attach [--detach-keys <keys>] [--no-stdin] <container>")
+	fmt.Println("cig cp <container>:<path> <host-path>|-")
+	fmt.Println("cig cp <host-path>|- <container>:<path>")
+	fmt.Println("cig top <container> [-o <field>,...]")
+	fmt.Println("cig stats [--no-stream] [--format <format>] [<container>...]")
+	fmt.Println("cig events [--since] [--until] [--filter key=value] [--format json|<template>]")
+	fmt.Println("cig start [-a] <container>")
+	fmt.Println("cig stop [-t <seconds>] <container>...")
+	fmt.Println("cig kill [-s <signal>] <container>...")
+	fmt.Println("cig pause <container>...")
+	fmt.Println("cig unpause <container>...")
+	fmt.Println("cig wait <container>...")
+	fmt.Println("cig rm [-f] <container>...")
+	fmt.Println("cig rename <container> <new-name>")
+	fmt.Println("cig logs [-f] [--since] [--until] [--tail N] [--timestamps] <container>")
+	fmt.Println("cig inspect [-f <template>] <container|image>...")
+	fmt.Println("cig images [-q] [--no-trunc] [--format <format>] [--filter key=value]")
 	fmt.Println("cig rmi <image-id>")
-	fmt.Println("cig ps")
+	fmt.Println("cig image squash [--from] <src-image> <dst-image>")
+	fmt.Println("cig image sign --key <private-key> <image>")
+	fmt.Println("cig image sbom [--format spdx-json|cyclonedx] <image>")
+	fmt.Println("cig ps [-a] [-q] [--no-trunc] [--format <format>] [--filter key=value]")
+	fmt.Println("cig cleanup [--dry-run]")
+}
+
+/*
+	Containers can be referred to by name, full ID or unique ID prefix
+*/
+
+func resolveContainer(ref string) string {
+	containerID, err := container.ResolveContainerID(ref)
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	return containerID
+}
+
+/*
+	Split a cp argument into container and path. Host paths containing a
+	colon can be written as ./path or as an absolute path.
+*/
+
+func splitCopyArg(arg string) (string, string, bool) {
+	if arg == "-" || strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg, false
+	}
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) < 2 {
+		return "", arg, false
+	}
+	return parts[0], parts[1], true
 }
 
 func main() {
-	options := []string{"run", "child-mode", "setup-netns", "setup-veth", "ps", "exec", "images", "rmi"}
+	options := []string{"run", "child-mode", "setup-netns", "setup-veth", "shim", "cp-helper", "ps", "exec", "attach", "cp", "top", "stats", "events", "start", "stop", "kill", "pause", "unpause", "wait", "rm", "rename", "inspect", "logs", "images", "rmi", "image", "cleanup"}
 
 	/* Check if arguments are valid */
 	if len(os.Args) < 2 || !utils.StringInSlice(os.Args[1], options) {
 		usage()
-		os.Exit(1)
+		os.Exit(utils.ExitCodeRuntimeError)
 	}
 
 	/* Seed the random number generator */
@@ -40,15 +90,25 @@ func main() {
 
 	/* We chroot and write to privileged directories. We need to be root */
 	if os.Geteuid() != 0 {
-		log.Fatal("You need root privileges to run this program. Please run again with root privileges")
+		utils.Fatal("You need root privileges to run this program. Please run again with root privileges")
 	}
 
 	/* Create the directories we require */
 	if err := utils.InitCigDirs(); err != nil {
-		log.Fatalf("Unable to create directories required: %v", err)
+		utils.Fatalf("Unable to create directories required: %v", err)
 	}
 
 	log.Printf("Cmd args: %v\n", os.Args)
+
+	/*
+		Tear down what crashed containers and cig processes left behind
+		before starting containers, which may need their names, addresses
+		and links. Everything else leaves it to cig cleanup.
+	*/
+	if os.Args[1] == "run" || os.Args[1] == "start" {
+		container.Cleanup(false)
+	}
+
 	switch os.Args[1] {
 	/*
 		Case run:
@@ -63,21 +123,73 @@ func main() {
 		swap := fs.Int("swap", -1, "Max swap to allow in MB")
 		pids := fs.Int("pids", -1, "Number of max processes to allow")
 		cpus := fs.Float64("cpus", -1, "Number of CPU cores to restrict to")
+		storageOpts := fs.StringArray("storage-opt", nil, "Storage driver options, e.g. size=2G")
+		detach := fs.BoolP("detach", "d", false, "Run the container in the background and print its ID")
+		logOpts := fs.StringArray("log-opt", nil, "Log options, e.g. max-size=10m,max-file=3")
+		autoRemove := fs.Bool("rm", false, "Remove the container when it exits")
+		name := fs.String("name", "", "Name of the container")
+		labelArgs := fs.StringArrayP("label", "l", nil, "Set metadata on the container, e.g. key=value")
+		restart := fs.String("restart", "no", "Restart policy: no, on-failure[:max], always or unless-stopped")
+		useInit := fs.Bool("init", false, "Run an init inside the container that reaps orphaned processes")
+		tty := fs.BoolP("tty", "t", false, "Allocate a pseudo-terminal for the container")
+		interactive := fs.BoolP("interactive", "i", false, "Keep stdin open and pass it to the container")
 		if err := fs.Parse(os.Args[2:]); err != nil {
 			fmt.Println("Error parsing: ", err)
 		}
 		if len(fs.Args()) < 2 {
-			log.Fatalf("Please pass image name and command to run")
+			utils.Fatalf("Please pass image name and command to run")
+		}
+		storageSize, err := container.ParseStorageOpts(*storageOpts)
+		if err != nil {
+			utils.Fatalf("Invalid storage options: %v", err)
+		}
+		logConfig, err := container.ParseLogOpts(*logOpts)
+		if err != nil {
+			utils.Fatalf("Invalid log options: %v", err)
 		}
 		/* Create and setup the CIG network bridge we need */
 		if isUp, _ := net.IsBridgeUp(); !isUp {
 			log.Println("Bringing up the cig0 bridge...")
 			if err := net.SetupBridge(); err != nil {
-				log.Fatalf("Unable to create cig0 bridge: %v", err)
+				utils.Fatalf("Unable to create cig0 bridge: %v", err)
 			}
 		}
 		log.Println("Bridge set up succesfully!")
-		container.InitContainer(*mem, *swap, *pids, *cpus, fs.Args()[0], fs.Args()[1:])
+		limits := utils.ContainerLimits{
+			Memory:      *mem,
+			Swap:        *swap,
+			Pids:        *pids,
+			Cpus:        *cpus,
+			StorageSize: storageSize,
+		}
+		labels := map[string]string{}
+		for _, label := range *labelArgs {
+			kv := strings.SplitN(label, "=", 2)
+			labels[kv[0]] = ""
+			if len(kv) == 2 {
+				labels[kv[0]] = kv[1]
+			}
+		}
+		restartPolicy, err := container.ParseRestartPolicy(*restart)
+		if err != nil {
+			utils.Fatalf("Invalid restart policy: %v", err)
+		}
+		if *autoRemove && restartPolicy.Name != "no" {
+			utils.Fatalf("--rm can't be combined with a restart policy")
+		}
+		opts := utils.RunOptions{
+			Name:          *name,
+			Limits:        limits,
+			LogConfig:     logConfig,
+			Detach:        *detach,
+			AutoRemove:    *autoRemove,
+			RestartPolicy: restartPolicy,
+			Init:          *useInit,
+			Labels:        labels,
+			Tty:           *tty,
+			OpenStdin:     *interactive,
+		}
+		os.Exit(container.InitContainer(fs.Args()[0], fs.Args()[1:], opts))
 
 	/*
 		Setup Network namespace for container.
@@ -91,7 +203,19 @@ func main() {
 	*/
 	case "setup-veth":
 		net.SetupContainerNetworkInterfaceStep1(os.Args[2])
-		net.SetupContainerNetworkInterfaceStep2(os.Args[2])
+		net.SetupContainerNetworkInterfaceStep2(os.Args[2], os.Args[3])
+
+	/*
+		Supervise a detached container, started by run -d.
+	*/
+	case "shim":
+		container.RunSupervisor(os.Args[2])
+
+	/*
+		Copy files in or out of a container from inside its root, for cp.
+	*/
+	case "cp-helper":
+		container.RunCopyHelper(os.Args[2:])
 
 	case "child-mode":
 		fs := flag.FlagSet{}
@@ -102,30 +226,284 @@ func main() {
 		pids := fs.Int("pids", -1, "Number of max processes to allow")
 		cpus := fs.Float64("cpus", -1, "Number of CPU cores to restrict to")
 		image := fs.String("img", "", "Container image")
+		useInit := fs.Bool("init", false, "Act as init for the workload")
+		tty := fs.Bool("tty", false, "The workload's terminal is passed as fd 3")
 		if err := fs.Parse(os.Args[2:]); err != nil {
 			fmt.Println("Error parsing: ", err)
 		}
 		if len(fs.Args()) < 2 {
-			log.Fatalf("Please pass image name and command to run")
+			utils.Fatalf("Please pass image name and command to run")
 		}
-		container.ExecContainerCommand(*mem, *swap, *pids, *cpus, fs.Args()[0], *image, fs.Args()[1:])
+		os.Exit(container.ExecContainerCommand(*mem, *swap, *pids, *cpus, *useInit, *tty, fs.Args()[0], *image, fs.Args()[1:]))
 
 	case "exec":
-		exec.ExecInContainer(os.Args[2])
+		fs := flag.FlagSet{}
+		/* Flags after the container belong to the command */
+		fs.SetInterspersed(false)
+
+		tty := fs.BoolP("tty", "t", false, "Allocate a pseudo-terminal for the command")
+		interactive := fs.BoolP("interactive", "i", false, "Keep stdin open and pass it to the command")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 2 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		os.Exit(exec.ExecInContainer(resolveContainer(fs.Args()[0]), fs.Args()[1:], *tty, *interactive))
+
+	case "attach":
+		fs := flag.FlagSet{}
+		detachKeys := fs.String("detach-keys", container.DefaultDetachKeys, "Key sequence that detaches from the container")
+		noStdin := fs.Bool("no-stdin", false, "Don't pass our input to the container")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass the container id")
+		}
+		os.Exit(container.AttachContainer(resolveContainer(fs.Args()[0]), *detachKeys, *noStdin))
+
+	case "cp":
+		if len(os.Args) < 4 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		srcRef, srcPath, srcInContainer := splitCopyArg(os.Args[2])
+		dstRef, dstPath, dstInContainer := splitCopyArg(os.Args[3])
+		switch {
+		case srcInContainer && !dstInContainer:
+			container.CopyFromContainer(resolveContainer(srcRef), srcPath, dstPath)
+		case dstInContainer && !srcInContainer:
+			container.CopyToContainer(srcPath, resolveContainer(dstRef), dstPath)
+		default:
+			utils.Fatalf("One of source and destination must be <container>:<path>, the other a host path or -")
+		}
+
+	case "top":
+		if len(os.Args) < 3 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		exec.PrintContainerProcesses(resolveContainer(os.Args[2]), os.Args[3:])
+
+	case "stats":
+		fs := flag.FlagSet{}
+		noStream := fs.Bool("no-stream", false, "Print the usage once instead of refreshing it")
+		opts := format.Options{}
+		fs.StringVar(&opts.Format, "format", "table", "Output format: table, json, or a Go template")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		var containerIDs []string
+		for _, ref := range fs.Args() {
+			containerIDs = append(containerIDs, resolveContainer(ref))
+		}
+		exec.PrintContainerStats(containerIDs, *noStream, opts)
+
+	case "events":
+		fs := flag.FlagSet{}
+		since := fs.String("since", "", "Show events since a timestamp or relative time, e.g. 10m")
+		until := fs.String("until", "", "Stop at a timestamp or relative time")
+		filters := fs.StringArrayP("filter", "f", nil, "Filter events, e.g. type=container or event=die")
+		formatText := fs.String("format", "", "Output format: json, or a Go template")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		exec.PrintEvents(*since, *until, *filters, *formatText)
+
+	case "cleanup":
+		fs := flag.FlagSet{}
+		dryRun := fs.Bool("dry-run", false, "Only show what would be cleaned up")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if container.Cleanup(*dryRun) == 0 {
+			log.Printf("Nothing to clean up\n")
+		}
+
+	case "start":
+		fs := flag.FlagSet{}
+		attach := fs.BoolP("attach", "a", false, "Attach to the container's output")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass the container id")
+		}
+		os.Exit(container.StartContainer(resolveContainer(fs.Args()[0]), *attach))
+
+	case "stop":
+		fs := flag.FlagSet{}
+		timeout := fs.IntP("time", "t", 10, "Seconds to wait for the container to stop before killing it")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass the container id")
+		}
+		for _, ref := range fs.Args() {
+			container.StopContainer(resolveContainer(ref), time.Duration(*timeout)*time.Second)
+		}
+
+	case "kill":
+		fs := flag.FlagSet{}
+		signal := fs.StringP("signal", "s", "KILL", "Signal to send to the container")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass the container id")
+		}
+		sig, err := container.ParseSignal(*signal)
+		if err != nil {
+			utils.Fatalf("Invalid signal: %v", err)
+		}
+		for _, ref := range fs.Args() {
+			container.KillContainer(resolveContainer(ref), sig)
+		}
+
+	case "rename":
+		if len(os.Args) < 4 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		container.RenameContainer(resolveContainer(os.Args[2]), os.Args[3])
+
+	case "pause", "unpause":
+		if len(os.Args) < 3 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		for _, ref := range os.Args[2:] {
+			if os.Args[1] == "pause" {
+				container.PauseContainer(resolveContainer(ref))
+			} else {
+				container.UnpauseContainer(resolveContainer(ref))
+			}
+		}
+
+	case "wait":
+		if len(os.Args) < 3 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		for _, ref := range os.Args[2:] {
+			fmt.Println(container.WaitContainer(resolveContainer(ref)))
+		}
+
+	case "rm":
+		fs := flag.FlagSet{}
+		force := fs.BoolP("force", "f", false, "Kill and remove running containers")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass the container id")
+		}
+		for _, ref := range fs.Args() {
+			container.RemoveContainer(resolveContainer(ref), *force)
+		}
+
+	case "logs":
+		fs := flag.FlagSet{}
+		follow := fs.BoolP("follow", "f", false, "Follow log output")
+		since := fs.String("since", "", "Show logs since a timestamp or relative time, e.g. 10m")
+		until := fs.String("until", "", "Show logs before a timestamp or relative time, e.g. 10m")
+		tail := fs.Int("tail", -1, "Number of lines to show from the end of the logs")
+		timestamps := fs.BoolP("timestamps", "t", false, "Show timestamps")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass the container id")
+		}
+		exec.PrintContainerLogs(resolveContainer(fs.Args()[0]), *follow, *since, *until, *tail, *timestamps)
 
 	case "ps":
-		exec.PrintRunningContainers()
+		fs := flag.FlagSet{}
+		all := fs.BoolP("all", "a", false, "Show all containers, not just running ones")
+		opts := format.Options{}
+		var filters []string
+		format.AddFlags(&fs, &opts, &filters)
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		exec.PrintContainers(*all, filters, opts)
+
+	case "inspect":
+		fs := flag.FlagSet{}
+		tmpl := fs.StringP("format", "f", "", "Format the output using a Go template")
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		if len(fs.Args()) < 1 {
+			utils.Fatalf("Please pass a container or image")
+		}
+		exec.InspectObjects(fs.Args(), *tmpl)
 
 	case "images":
-		image.PrintAvailableImages()
+		fs := flag.FlagSet{}
+		opts := format.Options{}
+		var filters []string
+		format.AddFlags(&fs, &opts, &filters)
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			fmt.Println("Error parsing: ", err)
+		}
+		image.PrintImages(filters, opts)
 
 	case "rmi":
 		if len(os.Args) < 3 {
 			usage()
-			os.Exit(1)
+			os.Exit(utils.ExitCodeRuntimeError)
 		}
 		exec.DeleteImageByHash(os.Args[2])
 
+	/*
+		Case image:
+			* subcommands that operate on images in the local store
+	*/
+	case "image":
+		if len(os.Args) < 3 {
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+		switch os.Args[2] {
+		case "squash":
+			fs := flag.FlagSet{}
+			from := fs.Int("from", 0, "Index of the first layer to squash")
+			if err := fs.Parse(os.Args[3:]); err != nil {
+				fmt.Println("Error parsing: ", err)
+			}
+			if len(fs.Args()) < 2 {
+				utils.Fatalf("Please pass source and destination image names")
+			}
+			image.SquashImage(fs.Args()[0], fs.Args()[1], *from)
+		case "sign":
+			fs := flag.FlagSet{}
+			key := fs.String("key", "", "PEM encoded ed25519 or ECDSA private key")
+			if err := fs.Parse(os.Args[3:]); err != nil {
+				fmt.Println("Error parsing: ", err)
+			}
+			if len(fs.Args()) < 1 || len(*key) == 0 {
+				utils.Fatalf("Please pass a signing key and the image to sign")
+			}
+			image.SignImage(fs.Args()[0], *key)
+		case "sbom":
+			fs := flag.FlagSet{}
+			format := fs.String("format", "spdx-json", "SBOM format, spdx-json or cyclonedx")
+			if err := fs.Parse(os.Args[3:]); err != nil {
+				fmt.Println("Error parsing: ", err)
+			}
+			if len(fs.Args()) < 1 {
+				utils.Fatalf("Please pass the image to describe")
+			}
+			image.PrintImageSBOM(fs.Args()[0], *format)
+		default:
+			usage()
+			os.Exit(utils.ExitCodeRuntimeError)
+		}
+
 	default:
 		usage()
 
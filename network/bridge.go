@@ -1,11 +1,15 @@
 package network
 
 import (
+	"ContainInGo/events"
 	"log"
 
 	"github.com/vishvananda/netlink"
 )
 
+const BridgeName = "cig0"
+const BridgeIP = "172.29.0.1"
+
 /*
 	Go through the list of interfaces and return true if the cig0 bridge is up
 */
@@ -16,7 +20,7 @@ func IsBridgeUp() (bool, error) {
 		return false, err
 	} else {
 		for _, link := range links {
-			if link.Type() == "bridge" && link.Attrs().Name == "cig0" {
+			if link.Type() == "bridge" && link.Attrs().Name == BridgeName {
 				return true, nil
 			}
 		}
@@ -33,13 +37,14 @@ func IsBridgeUp() (bool, error) {
 
 func SetupBridge() error {
 	linkAttrs := netlink.NewLinkAttrs()
-	linkAttrs.Name = "cig0"
+	linkAttrs.Name = BridgeName
 	gockerBridge := &netlink.Bridge{LinkAttrs: linkAttrs}
 	if err := netlink.LinkAdd(gockerBridge); err != nil {
 		return err
 	}
-	addr, _ := netlink.ParseAddr("172.29.0.1/16")
+	addr, _ := netlink.ParseAddr(BridgeIP + "/16")
 	netlink.AddrAdd(gockerBridge, addr)
 	netlink.LinkSetUp(gockerBridge)
+	events.Log(events.TypeNetwork, "create", BridgeName, map[string]string{"type": "bridge"})
 	return nil
 }
@@ -1,13 +1,15 @@
 package network
 
 import (
+	"ContainInGo/events"
 	"github.com/vishvananda/netlink"
 	"math/rand"
 	"net"
 	"fmt"
+	"strings"
 )
 
-func createMACAddress() net.HardwareAddr {
+func CreateMACAddress() net.HardwareAddr {
 	hw := make(net.HardwareAddr, 6)
 	hw[0] = 0x02
 	hw[1] = 0x42
@@ -15,28 +17,85 @@ func createMACAddress() net.HardwareAddr {
 	return hw
 }
 
-func CreateIPAddress() string {
-	byte1 := rand.Intn(254)
-	byte2 := rand.Intn(254)
-	return fmt.Sprintf("172.29.%d.%d", byte1, byte2)
+/*
+	Pick a random address on the bridge's network that isn't among used,
+	the network's own address or the bridge's
+*/
+
+func CreateIPAddress(used map[string]bool) (string, error) {
+	const count = 254 * 254
+	start := rand.Intn(count)
+	for i := 0; i < count; i++ {
+		n := (start + i) % count
+		ip := fmt.Sprintf("172.29.%d.%d", n/254, n%254)
+		if n == 0 || ip == BridgeIP || used[ip] {
+			continue
+		}
+		return ip, nil
+	}
+	return "", fmt.Errorf("no free IP address left on %s", BridgeName)
+}
+
+/*
+	The host end of a container's veth pair stays attached to the bridge,
+	the other end is moved into the container's network namespace.
+*/
+
+func GetHostVethName(containerID string) string {
+	return "veth0_" + containerID[:6]
 }
 
-func SetupVirtualEthOnHost(containerID string) error {
-	veth0 := "veth0_" + containerID[:6]
-	veth1 := "veth1_" + containerID[:6]
+func GetContainerVethName(containerID string) string {
+	return "veth1_" + containerID[:6]
+}
+
+func SetupVirtualEthOnHost(containerID string, mac net.HardwareAddr) error {
+	veth0 := GetHostVethName(containerID)
+	veth1 := GetContainerVethName(containerID)
 	linkAttrs := netlink.NewLinkAttrs()
 	linkAttrs.Name = veth0
 	veth0Struct := &netlink.Veth{
 		LinkAttrs:        linkAttrs,
 		PeerName:         veth1,
-		PeerHardwareAddr: createMACAddress(),
+		PeerHardwareAddr: mac,
 	}
 	if err := netlink.LinkAdd(veth0Struct); err != nil {
 		return err
 	}
 	netlink.LinkSetUp(veth0Struct)
-	gockerBridge, _ := netlink.LinkByName("gocker0")
-	netlink.LinkSetMaster(veth0Struct, gockerBridge)
+	cigBridge, _ := netlink.LinkByName(BridgeName)
+	netlink.LinkSetMaster(veth0Struct, cigBridge)
+	events.Log(events.TypeNetwork, "connect", BridgeName, map[string]string{"container": containerID})
 
 	return nil
-}
\ No newline at end of file
+}
+/*
+	Names of all the veth links on the host that look like ours
+*/
+
+func ListVethNames() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() == "veth" && (strings.HasPrefix(name, "veth0_") || strings.HasPrefix(name, "veth1_")) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+/*
+	Delete a veth link, which takes its peer with it
+*/
+
+func DeleteVeth(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkDel(link)
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	Write the file or directory tree at path to w as a tar stream, with its
+	entries named under name. Symlinks are archived as links, not followed.
+*/
+
+func WriteTar(w io.Writer, path string, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(path, file)
+		/* Not filepath.Join, which would drop the "./" of name "." */
+		header.Name = name
+		if rel != "." {
+			header.Name += "/" + filepath.ToSlash(rel)
+		}
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+/*
+	Fail if any directory between root and its entry rel is a symlink, so
+	that an archive can't plant a link and then write through it.
+*/
+
+func checkNoSymlinks(root string, rel string) error {
+	dir := root
+	parts := strings.Split(filepath.Dir(rel), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s: path goes through a symlink", rel)
+		}
+	}
+	return nil
+}
+
+/*
+	Extract the tar stream r at dst. With rebase set, dst names the copy of
+	what was archived, as with cp: it goes inside dst if that is an existing
+	directory, and is renamed to dst otherwise. Without it, dst must be an
+	existing directory to extract into. Entries are confined to dst and
+	keep the modes, owners and times they were archived with.
+*/
+
+func ExtractTar(r io.Reader, dst string, rebase bool) error {
+	root, newName := dst, ""
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		rebase = false
+	} else if !rebase {
+		return fmt.Errorf("%s is not a directory", dst)
+	} else {
+		root, newName = filepath.Dir(dst), filepath.Base(dst)
+	}
+	/* The path within root an entry name, or a hard link's target, refers to */
+	resolve := func(name string) (string, error) {
+		/* Rebase before cleaning the name, which would drop a leading "./" */
+		if rebase {
+			parts := strings.SplitN(name, "/", 2)
+			parts[0] = newName
+			name = strings.Join(parts, "/")
+		}
+		rel := filepath.Clean(filepath.FromSlash(name))
+		if rel == ".." || filepath.IsAbs(rel) || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s: path is outside of the destination", name)
+		}
+		if err := checkNoSymlinks(root, rel); err != nil {
+			return "", err
+		}
+		return filepath.Join(root, rel), nil
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path, err := resolve(header.Name)
+		if err != nil {
+			return err
+		}
+		var linkTarget string
+		if header.Typeflag == tar.TypeLink {
+			if linkTarget, err = resolve(header.Linkname); err != nil {
+				return err
+			}
+		}
+		if err := extractTarEntry(tr, header, path, linkTarget); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+	Extract a single entry at path. Hard links are made to linkTarget, an
+	entry extracted before, and share its mode, owner and times.
+*/
+
+func extractTarEntry(tr *tar.Reader, header *tar.Header, path string, linkTarget string) error {
+	info := header.FileInfo()
+	existing, err := os.Lstat(path)
+	if err == nil && !(existing.IsDir() && info.IsDir()) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(header.Linkname, path); err != nil {
+			return err
+		}
+		return os.Lchown(path, header.Uid, header.Gid)
+	case tar.TypeLink:
+		target, err := os.Lstat(linkTarget)
+		if err != nil {
+			return fmt.Errorf("%s: hard link target %s: %v", header.Name, header.Linkname, err)
+		}
+		if target.IsDir() {
+			return fmt.Errorf("%s: hard link to directory %s", header.Name, header.Linkname)
+		}
+		return os.Link(linkTarget, path)
+	case tar.TypeReg:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	default:
+		log.Printf("Warning: skipping %s, file type %c is not supported", header.Name, header.Typeflag)
+		return nil
+	}
+	if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(path, header.ModTime, header.ModTime)
+}
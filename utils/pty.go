@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Allocate a pseudo-terminal pair. The slave end becomes the terminal of
+	the workload, we keep the master end.
+*/
+
+func OpenPty() (master *os.File, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+func IsTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+/*
+	Put the terminal fd in raw mode, as cfmakeraw(3) does, and return its
+	previous settings
+*/
+
+func MakeRaw(fd int) (*unix.Termios, error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	saved := *termios
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR |
+		unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+/*
+	Give the pty the window size of the terminal fd
+*/
+
+func ResizePty(pty *os.File, fd int) error {
+	winsize, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, winsize)
+}
+
+/*
+	Connect our terminal to the pty: put it in raw mode, so that keys like
+	Ctrl-C reach the workload, keep the pty's size in step with it and,
+	with stdin set, feed it our input. The returned function restores the
+	terminal. Output from the pty is up to the caller.
+*/
+
+func AttachTerminal(pty *os.File, stdin bool) (restore func()) {
+	if stdin {
+		go io.Copy(pty, os.Stdin)
+	}
+	fd := int(os.Stdin.Fd())
+	if !IsTerminal(fd) {
+		return func() {}
+	}
+	saved, err := MakeRaw(fd)
+	if err != nil {
+		return func() {}
+	}
+	ResizePty(pty, fd)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	go func() {
+		for range winch {
+			ResizePty(pty, fd)
+		}
+	}()
+	return func() {
+		signal.Stop(winch)
+		close(winch)
+		unix.IoctlSetTermios(fd, unix.TCSETS, saved)
+	}
+}
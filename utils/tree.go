@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Copy the directory tree at src to dst, preserving ownership and modes.
+	Regular files are hard linked when hardLink is set, otherwise they are
+	reflinked where the filesystem supports it and copied where it doesn't.
+*/
+
+func CopyTree(src string, dst string, hardLink bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		return CopyEntry(path, filepath.Join(dst, rel), info, hardLink)
+	})
+}
+
+/*
+	Copy the single file system entry src described by info to dst,
+	replacing whatever is at dst unless both are directories.
+*/
+
+func CopyEntry(src string, dst string, info os.FileInfo, hardLink bool) error {
+	existing, err := os.Lstat(dst)
+	if err == nil && !(existing.IsDir() && info.IsDir()) {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+	stat, _ := info.Sys().(*syscall.Stat_t)
+
+	mode := info.Mode()
+	perm := mode.Perm() | mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)
+	switch {
+	case mode.IsDir():
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, perm); err != nil {
+			return err
+		}
+	case mode&os.ModeSymlink != 0:
+		linkTarget, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(linkTarget, dst); err != nil {
+			return err
+		}
+	case mode.IsRegular():
+		if hardLink {
+			if err := os.Link(src, dst); err == nil {
+				return nil
+			}
+		}
+		if err := cloneFile(src, dst); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, perm); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	default:
+		if stat == nil {
+			return fmt.Errorf("unsupported file type: %s", src)
+		}
+		if err := unix.Mknod(dst, stat.Mode, int(stat.Rdev)); err != nil {
+			return err
+		}
+	}
+	if stat != nil {
+		return os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+	}
+	return nil
+}
+
+/*
+	Reflink src to dst, falling back to a plain copy.
+*/
+
+func cloneFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err == nil {
+		return nil
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -1,5 +1,15 @@
 package utils
 
+import "time"
+
+const (
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+	/* Waiting to be started again by its restart policy */
+	StatusRestarting = "restarting"
+)
+
 /*
 	This is the format of our imageDB file where we store the
 	list of images we have on the system.
@@ -28,16 +38,92 @@ type (
 		Layers   []string
 	}
 	ImageConfigDetails struct {
-		Env []string `json:"Env"`
-		Cmd []string `json:"Cmd"`
+		Env        []string          `json:"Env"`
+		Cmd        []string          `json:"Cmd"`
+		StopSignal string            `json:"StopSignal"`
+		Labels     map[string]string `json:"Labels"`
 	}
 	ImageConfig struct {
-		Config ImageConfigDetails `json:"Config"`
+		Created time.Time          `json:"created"`
+		Config  ImageConfigDetails `json:"Config"`
+	}
+	CigConfig struct {
+		Snapshotter string `json:"snapshotter"`
+	}
+	Mount struct {
+		Source string
+		Target string
+		Type   string
+		Flags  uintptr
+		Data   string
+		/* Directory that relative paths in Data are resolved against */
+		WorkDir string `json:",omitempty"`
+	}
+	ContainerLimits struct {
+		Memory      int
+		Swap        int
+		Pids        int
+		Cpus        float64
+		StorageSize int64
+	}
+	/*
+		How cig run was asked to run a container
+	*/
+	RunOptions struct {
+		Name          string
+		Limits        ContainerLimits
+		LogConfig     LogConfig
+		Detach        bool
+		AutoRemove    bool
+		RestartPolicy RestartPolicy
+		Init          bool
+		Labels        map[string]string
+		Tty           bool
+		OpenStdin     bool
+	}
+	RestartPolicy struct {
+		Name              string
+		MaximumRetryCount int
+	}
+	LogConfig struct {
+		MaxSize  int64
+		MaxFiles int
 	}
-	RunningContainerInfo struct {
-		ContainerId string
-		Image       string
-		Command     string
-		Pid         int
+	/*
+		Everything we know about a container. It is kept in the container's
+		state.json and rewritten at every step of the container's life.
+	*/
+	ContainerState struct {
+		ID            string
+		Name          string
+		Image         string
+		ImageID       string
+		ImageDigest   string
+		Args          []string
+		Limits        ContainerLimits
+		LogConfig     LogConfig
+		AutoRemove    bool
+		RestartPolicy RestartPolicy
+		RestartCount  int
+		StoppedByUser bool
+		Init          bool
+		Labels        map[string]string
+		Tty           bool
+		OpenStdin     bool
+		Snapshotter   string
+		Mounts        []Mount
+		Pid           int
+		SupervisorPid int
+		Status        string
+		Paused        bool
+		ExitCode      int
+		Created       time.Time
+		StartedAt     time.Time
+		FinishedAt    time.Time
+		IPAddress     string
+		MacAddress    string
+		Bridge        string
+		HostVeth      string
+		ContainerVeth string
 	}
 )
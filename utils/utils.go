@@ -2,15 +2,20 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 )
 
 const cigHomePath = "/var/lib/cig"
 const cigTempPath = cigHomePath + "/tmp"
 const cigImagesPath = cigHomePath + "/images"
+const cigLayerLinksPath = cigHomePath + "/l"
+const cigStoragePath = cigHomePath + "/storage"
+const cigSignaturesPath = cigHomePath + "/signatures"
 const cigContainersPath = "/var/run/cig/containers"
 const cigNetNsPath = "/var/run/cig/net-ns"
 
@@ -19,6 +24,21 @@ func GetCigImagesPath() string {
 	return cigImagesPath
 }
 
+// return cigLayerLinksPath if it exists
+func GetCigLayerLinksPath() string {
+	return cigLayerLinksPath
+}
+
+// return cigStoragePath if it exists
+func GetCigStoragePath() string {
+	return cigStoragePath
+}
+
+// return cigSignaturesPath if it exists
+func GetCigSignaturesPath() string {
+	return cigSignaturesPath
+}
+
 // return cigTempPath if it exists
 func GetCigTempPath() string {
 	return cigTempPath
@@ -49,7 +69,7 @@ func StringInSlice(a string, list []string) bool {
 }
 
 func InitCigDirs() (err error) {
-	dirs := []string{cigHomePath, cigTempPath, cigImagesPath, cigContainersPath}
+	dirs := []string{cigHomePath, cigTempPath, cigImagesPath, cigLayerLinksPath, cigStoragePath, cigSignaturesPath, cigContainersPath}
 	return CreateDirsIfDontExist(dirs)
 }
 
@@ -78,6 +98,22 @@ func ParseManifest(manifestPath string, mani *Manifest) error {
 	return nil
 }
 
+/*
+	Read the optional cig configuration file from the cig home directory.
+	A missing file leaves cfg untouched.
+*/
+
+func ParseCigConfig(cfg *CigConfig) error {
+	data, err := ioutil.ReadFile(cigHomePath + "/config.json")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, cfg)
+}
+
 func CopyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -107,14 +143,77 @@ func DeleteFiles(path string) {
 		"Unable to file: "+path)
 }
 
+/*
+	Format a byte count for humans, e.g. 1.5GB
+*/
+
+func HumanSize(bytes int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	size := float64(bytes)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", size, units[i])
+}
+
+/*
+	Format a duration the way people say it, e.g. "5 minutes"
+*/
+
+func HumanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int(d.Seconds()))
+	case d < 2*time.Minute:
+		return "About a minute"
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 2*time.Hour:
+		return "About an hour"
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+/*
+	Exit codes of cig itself, set apart from those of the workload the way
+	docker does it. A workload killed by a signal exits with
+	ExitCodeSignalBase plus the signal number.
+*/
+
+const (
+	ExitCodeRuntimeError = 125
+	ExitCodeCannotInvoke = 126
+	ExitCodeNotFound     = 127
+	ExitCodeSignalBase   = 128
+)
+
+/*
+	Like log.Fatal and log.Fatalf, but exiting with ExitCodeRuntimeError so
+	failures of cig can't be mistaken for the workload's exit code.
+*/
+
+func Fatal(v ...interface{}) {
+	log.Output(2, fmt.Sprint(v...))
+	os.Exit(ExitCodeRuntimeError)
+}
+
+func Fatalf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(ExitCodeRuntimeError)
+}
+
 func LogErr(err error) {
 	if err != nil {
-		log.Fatalf("Fatal error: %v\n", err)
+		Fatalf("Fatal error: %v\n", err)
 	}
 }
 
 func LogErrWithMsg(err error, msg string) {
 	if err != nil {
-		log.Fatalf("Fatal error: %s: %v\n", msg, err)
+		Fatalf("Fatal error: %s: %v\n", msg, err)
 	}
 }